@@ -6,7 +6,6 @@ import (
 	_ "net/http/pprof"
 	"syscall"
 
-	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 	"github.com/suryatresna/multiplayerengine/internal/chat"
 	"github.com/suryatresna/multiplayerengine/internal/epoll"
@@ -19,7 +18,7 @@ var (
 
 func wsHandler(w http.ResponseWriter, r *http.Request) {
 	// Upgrade connection
-	conn, _, _, err := ws.UpgradeHTTP(r, w)
+	conn, _, err := echat.Upgrade(r, w)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,101 @@
+package chat
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// session is a state of disconnected identity retained for ack window.
+type session struct {
+	acked   uint64
+	expires time.Time
+	// refresh is set when user had too many unacknowledged broadcasts, so
+	// they are not replayed and client must do full refresh.
+	refresh bool
+}
+
+// Acked returns highest broadcast sequence number acknowledged by user.
+func (u *User) Acked() uint64 {
+	return atomic.LoadUint64(&u.acked)
+}
+
+// Unacked returns number of broadcasts written to user but not acknowledged.
+func (u *User) Unacked() uint64 {
+	last, acked := u.LastSeq(), u.Acked()
+	if last <= acked {
+		return 0
+	}
+	return last - acked
+}
+
+// ack stores sequence number acknowledged by client.
+func (u *User) ack(seq uint64) {
+	for {
+		acked := atomic.LoadUint64(&u.acked)
+		if seq <= acked || atomic.CompareAndSwapUint64(&u.acked, acked, seq) {
+			return
+		}
+	}
+}
+
+// saveSession retains acknowledged position of removed user with identity.
+// mutex must be held.
+func (c *Chat) saveSession(user *User) {
+	if c.ackWindow <= 0 || user.identity == "" {
+		return
+	}
+	identity := user.identity
+	c.ss[identity] = session{
+		acked:   user.Acked(),
+		expires: time.Now().Add(c.ackWindow),
+		refresh: c.maxUnacked > 0 && user.Unacked() > uint64(c.maxUnacked),
+	}
+	time.AfterFunc(c.ackWindow, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if s, has := c.ss[identity]; has && !time.Now().Before(s.expires) {
+			delete(c.ss, identity)
+		}
+	})
+}
+
+// takeSession returns retained session of identity which reconnected within
+// ack window.
+// mutex must be held.
+func (c *Chat) takeSession(identity string) (s session, ok bool) {
+	s, has := c.ss[identity]
+	if !has {
+		return s, false
+	}
+	delete(c.ss, identity)
+	if time.Now().After(s.expires) {
+		return s, false
+	}
+	return s, true
+}
+
+// replayTo writes to user retained broadcasts after acknowledged sequence
+// number of session. If some of them are not retained anymore, or session
+// must be refreshed, "gap" notice is written first so client could do full
+// refresh.
+func (c *Chat) replayTo(u *User, s session) error {
+	acked := s.acked
+	if s.refresh {
+		u.ack(acked)
+		return u.writeNotice("gap", Object{"seq": acked})
+	}
+	reqs, gap := c.Replay(u, acked)
+	if gap {
+		if err := u.writeNotice("gap", Object{"seq": acked}); err != nil {
+			return err
+		}
+	}
+	for _, r := range reqs {
+		if err := u.write(r); err != nil {
+			return err
+		}
+	}
+	u.ack(acked)
+	return nil
+}
@@ -0,0 +1,19 @@
+package chat
+
+// validAttachment reports whether a is a valid attachment metadata object,
+// that is {"url": string, "mime": string, "size": number} with allowed mime
+// type and size not greater than the configured maximum.
+func (c *Chat) validAttachment(a Object) bool {
+	url, _ := a.GetString("url")
+	mime, _ := a.GetString("mime")
+	size, ok := a.GetFloat("size")
+	if url == "" || !ok || size < 0 || size > float64(c.maxAttachmentSize) {
+		return false
+	}
+	for _, m := range c.attachmentMimes {
+		if m == mime {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package chat
+
+import "math/rand"
+
+// randAvatar picks avatar from the chat avatars pool. It prefers avatars
+// which are not used by anyone. When all of them are taken, some avatar is
+// reused.
+// mutex must be held.
+func (c *Chat) randAvatar() string {
+	if len(c.avatars) == 0 {
+		return ""
+	}
+	var free []string
+	for _, a := range c.avatars {
+		if c.as[a] == 0 {
+			free = append(free, a)
+		}
+	}
+	var avatar string
+	if len(free) > 0 {
+		avatar = free[rand.Intn(len(free))]
+	} else {
+		avatar = c.avatars[rand.Intn(len(c.avatars))]
+	}
+	c.as[avatar]++
+
+	return avatar
+}
+
+// releaseAvatar returns user's avatar back to the pool.
+// mutex must be held.
+func (c *Chat) releaseAvatar(user *User) {
+	if user.avatar == "" {
+		return
+	}
+	if c.as[user.avatar]--; c.as[user.avatar] <= 0 {
+		delete(c.as, user.avatar)
+	}
+}
+
+// withAvatar sets "avatar" field of params if user has one.
+func withAvatar(user *User, params Object) Object {
+	if user.avatar != "" {
+		params["avatar"] = user.avatar
+	}
+	return params
+}
@@ -0,0 +1,33 @@
+package chat
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBuffer is the maximum capacity of read buffer returned to the
+// pool, so single large message does not pin memory.
+const maxPooledBuffer = 64 << 10
+
+// buffers reuses buffers of received messages across connections.
+var buffers = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer(size int64) *bytes.Buffer {
+	buf := buffers.Get().(*bytes.Buffer)
+	if size > 0 && size <= maxPooledBuffer {
+		buf.Grow(int(size))
+	}
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	buffers.Put(buf)
+}
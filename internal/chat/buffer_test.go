@@ -0,0 +1,124 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// streamConn reads from r and discards writes.
+type streamConn struct {
+	r io.Reader
+}
+
+func (c streamConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c streamConn) Write(p []byte) (int, error) { return len(p), nil }
+func (c streamConn) Close() error                { return nil }
+
+// loop returns the same bytes forever.
+type loop struct {
+	p   []byte
+	off int
+}
+
+func (l *loop) Read(p []byte) (int, error) {
+	n := copy(p, l.p[l.off:])
+	l.off = (l.off + n) % len(l.p)
+	return n, nil
+}
+
+// clientFrames returns payload masked as client message split into n
+// frames.
+func clientFrames(payload []byte, n int) []byte {
+	var buf bytes.Buffer
+	size := (len(payload) + n - 1) / n
+	for i := 0; i < n; i++ {
+		op := ws.OpContinuation
+		if i == 0 {
+			op = ws.OpText
+		}
+		lo, hi := i*size, (i+1)*size
+		if lo > len(payload) {
+			lo = len(payload)
+		}
+		if hi > len(payload) {
+			hi = len(payload)
+		}
+		f := ws.NewFrame(op, i == n-1, append([]byte(nil), payload[lo:hi]...))
+		ws.WriteFrame(&buf, ws.MaskFrame(f))
+	}
+	return buf.Bytes()
+}
+
+func TestReadRequestFragmented(t *testing.T) {
+	c := NewChat(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var stream bytes.Buffer
+			for j := 0; j < 20; j++ {
+				text := fmt.Sprintf("%d-%d-%s", i, j, bytes.Repeat([]byte("x"), i*j*10))
+				payload, _ := json.Marshal(Request{
+					Method: "publish",
+					Params: Object{"text": text},
+				})
+				stream.Write(clientFrames(payload, j%4+1))
+			}
+			u := &User{chat: c, conn: streamConn{&stream}}
+			for j := 0; j < 20; j++ {
+				req, err := u.readRequest()
+				if err != nil {
+					t.Errorf("readRequest() error: %v", err)
+					return
+				}
+				want := fmt.Sprintf("%d-%d-", i, j)
+				if text, _ := req.Params.GetString("text"); !bytes.HasPrefix([]byte(text), []byte(want)) || len(text) != len(want)+i*j*10 {
+					t.Errorf("decoded text %.20q...; want prefix %q", text, want)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkReadRequest(b *testing.B) {
+	payload, _ := json.Marshal(Request{
+		Method: "publish",
+		Params: Object{"text": "hello, world"},
+	})
+	frames := clientFrames(payload, 1)
+
+	b.Run("pooled", func(b *testing.B) {
+		u := &User{chat: NewChat(nil), conn: streamConn{&loop{p: frames}}}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := u.readRequest(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	// Decoder is how requests were read before pooled buffers.
+	b.Run("decoder", func(b *testing.B) {
+		conn := streamConn{&loop{p: frames}}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, r, err := wsutil.NextReader(conn, ws.StateServerSide)
+			if err != nil {
+				b.Fatal(err)
+			}
+			req := &Request{}
+			if err := json.NewDecoder(r).Decode(req); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
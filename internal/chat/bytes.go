@@ -0,0 +1,68 @@
+package chat
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrByteBudget returned by Receive when user exceeded byte budget set by
+// WithByteBudget().
+var ErrByteBudget = fmt.Errorf("chat: byte budget exceeded")
+
+// budget counts bytes received from user during fixed window. Guarded by u.io
+// mutex.
+type budget struct {
+	start time.Time
+	bytes int64
+}
+
+// BytesReceived returns total length of messages received from user.
+func (u *User) BytesReceived() uint64 {
+	return atomic.LoadUint64(&u.bytesIn)
+}
+
+// BytesWritten returns total number of bytes written to user connection.
+func (u *User) BytesWritten() uint64 {
+	return atomic.LoadUint64(&u.bytesOut)
+}
+
+// allowance returns number of bytes user could send in the current window,
+// or -1 if there is no budget. It must be called with u.io held.
+func (u *User) allowance() int64 {
+	c := u.chat
+	if c.byteBudget <= 0 {
+		return -1
+	}
+	now := time.Now()
+	if now.Sub(u.budget.start) >= c.budgetWindow {
+		u.budget.start = now
+		u.budget.bytes = 0
+	}
+	if n := c.byteBudget - u.budget.bytes; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// received counts n received bytes. It returns ErrByteBudget if user
+// exceeded the budget. It must be called with u.io held.
+func (u *User) received(n int64) error {
+	atomic.AddUint64(&u.bytesIn, uint64(n))
+	atomic.AddUint64(&u.chat.metrics.bytesIn, uint64(n))
+
+	if u.chat.byteBudget <= 0 {
+		return nil
+	}
+	u.budget.bytes += n
+	if u.budget.bytes > u.chat.byteBudget {
+		return ErrByteBudget
+	}
+	return nil
+}
+
+// written counts n bytes written to user connection.
+func (u *User) written(n int) {
+	atomic.AddUint64(&u.bytesOut, uint64(n))
+	atomic.AddUint64(&u.chat.metrics.bytesOut, uint64(n))
+}
@@ -0,0 +1,66 @@
+package chat_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/suryatresna/multiplayerengine/internal/chat"
+)
+
+// writeFragmented writes payload as empty text frame followed by
+// continuation frame.
+func writeFragmented(p peer, payload []byte) error {
+	for _, f := range []ws.Frame{
+		ws.NewFrame(ws.OpText, false, nil),
+		ws.NewFrame(ws.OpContinuation, true, payload),
+	} {
+		if err := ws.WriteFrame(p.raw, ws.MaskFrame(f)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestBytesReceivedFragmented(t *testing.T) {
+	c := chat.NewChat(nil)
+	p := connect(t, c)
+	flush(t, c)
+
+	payload := []byte(`{"method":"typing","params":{"pad":"` + string(bytes.Repeat([]byte("x"), 200)) + `"}}`)
+	before := p.u.BytesReceived()
+	if err := writeFragmented(p, payload); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.cl.Call(callContext(t), "count", nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.u.BytesReceived() - before; n < uint64(len(payload)) {
+		t.Errorf("received %d bytes; want at least %d", n, len(payload))
+	}
+}
+
+func TestByteBudgetFragmented(t *testing.T) {
+	c := chat.NewChat(nil, chat.WithByteBudget(100, time.Minute))
+	p := connect(t, c)
+	flush(t, c)
+
+	// Write could fail as server closes connection before reading all.
+	writeFragmented(p, bytes.Repeat([]byte("x"), 1000))
+
+	tm := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-p.cl.Subscribe():
+			if !ok {
+				if r := p.u.CloseReason(); r != chat.CloseRate {
+					t.Errorf("close reason is %v; want %v", r, chat.CloseRate)
+				}
+				return
+			}
+		case <-tm:
+			t.Fatal("connection is not closed")
+		}
+	}
+}
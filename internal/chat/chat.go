@@ -2,13 +2,20 @@ package chat
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
@@ -27,87 +34,387 @@ type GopoolInterface interface {
 // performance.
 type Object map[string]interface{}
 
+// Request represents json-rpc request or notice.
+// ID is kept as sent by client, which could be a string, a number or null,
+// so it is echoed verbatim in responses. Notices have no ID.
 type Request struct {
-	ID     int    `json:"id"`
-	Method string `json:"method"`
-	Params Object `json:"params"`
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params Object          `json:"params"`
 }
 
 type Response struct {
-	ID     int    `json:"id"`
-	Result Object `json:"result"`
+	ID     json.RawMessage `json:"id"`
+	Result Object          `json:"result"`
 }
 
 type Error struct {
-	ID    int    `json:"id"`
-	Error Object `json:"error"`
+	ID    json.RawMessage `json:"id"`
+	Error Object          `json:"error"`
 }
 
+// SchedulePolicy defines how broadcast writes are scheduled when pool is
+// saturated.
+type SchedulePolicy int
+
+const (
+	// ScheduleBlock waits for free pool worker.
+	ScheduleBlock SchedulePolicy = iota
+	// ScheduleShed drops the write.
+	ScheduleShed
+	// ScheduleInline writes in the writer goroutine.
+	ScheduleInline
+)
+
+// ErrNoSuchUser returned by Chat when there is no user with given name.
+var ErrNoSuchUser = fmt.Errorf("chat: no such user")
+
+// ErrDraining returned by Register when chat is draining.
+var ErrDraining = fmt.Errorf("chat: server is draining")
+
 // Chat contains logic of user interaction.
 type Chat struct {
+	// Accessed atomically, must be 64-bit aligned.
+	dropped   uint64
+	shed      uint64 // Number of broadcasts shed by global rate or full queue.
+	connected int64
+	buffered  int64 // Number of broadcasts buffered for paused users.
+	metrics   metrics
+	draining  uint32
+
+	started time.Time
+
 	mu  sync.RWMutex
 	seq uint
 	us  []*User
+	na  int // Number of authenticated users.
 	ns  map[string]*User
+	as  map[string]int // Number of users per avatar.
+	ids map[string]*User
+	ss  map[string]session   // Sessions of disconnected identities.
+	ts  map[string]time.Time // Tombstones of removed users.
+
+	holds map[string]hold   // Names held for identities of removed users.
+	held  map[string]string // Held names by identity.
+	// words is the pool of random names.
+	words []string
+
+	handlers map[string]handler
+	fallback HandlerFunc
+	// tags indexes users by tag key and value.
+	tags map[string]map[string]map[*User]bool
+	// watchers indexes users by watched names.
+	watchers map[string]map[*User]bool
+
+	bmu  sync.Mutex
+	bseq uint64 // Sequence number of the last broadcast.
+	h    history
 
 	pool GopoolInterface
-	out  chan []byte
+	out  chan *message
+	high chan *message
+	// Semaphores serializing sends to out and high, so broadcasts of each
+	// priority level are sequenced in the order of delivery.
+	outLock  chan struct{}
+	highLock chan struct{}
+
+	tracer  Tracer
+	welcome func(*User) Object
+	avatars []string
+	suffix  func(base string, attempt int) string
+
+	formatName func(string) string
+	maxTextLen int
+	localize   func(u *User, key string, data Object) string
+
+	anonymous       bool
+	anonymousAuthor string
+
+	pauseBuffer int
+	maxNack     int
+	names       *fieldNames
+	session     SessionPolicy
+	ackWindow   time.Duration
+	maxUnacked  int
+
+	tombstoneTTL time.Duration
+	nameHold     time.Duration
+	rate         *bucket
+	keepAlive    time.Duration
+	storm        *storm
+	lockout      *lockout
+	tickInterval time.Duration
+	probeSilence time.Duration
+	probeTimeout time.Duration
+	slowPolicy   SlowPolicy
+	maxPending   int
+	maxInFlight  int
+	onRemove     func(*User, CloseReason)
+	greetPolicy  GreetPolicy
+
+	renameCooldown time.Duration
+
+	done      chan struct{} // Closed by Shutdown.
+	closeDone sync.Once
+	stop      chan struct{} // Closed by Shutdown once writes are flushed.
+	closeStop sync.Once
+
+	maxUsers   int
+	maxWaiting int
+	wait       []*User // Guarded by mutex.
+
+	pins    []pinned // Guarded by mutex.
+	maxPins int
+	admin   func(*User) bool
+
+	byteBudget   int64
+	budgetWindow time.Duration
+
+	maxLifetime    time.Duration
+	lifetimeJitter time.Duration
+
+	queueSize    int
+	queueTimeout time.Duration
+
+	squelch         *squelch
+	receipts        *receipts
+	idempotencySize int
+	idempotencyTTL  time.Duration
+	order           Ordering
+	workers         int
+	ringSize        int
+	ready           chan *User
+	drainTimeout    time.Duration
+
+	idleTimeout   time.Duration
+	sweepInterval time.Duration
+
+	readTimeout time.Duration
+	maxDepth    int
+
+	writeTimeout time.Duration
+	classify     func(*User, error) WriteErrorClass
+	chunkSize    int
+	chunkTimeout time.Duration
+
+	policy          SchedulePolicy
+	scheduleTimeout time.Duration
+
+	infoFields []string
+
+	co     coalescer
+	roster roster
+	flight inflight
+
+	subprotocols      []string
+	strictSubprotocol bool
+
+	maxAttachmentSize int64
+	attachmentMimes   []string
+
+	reauthInterval time.Duration
+	reauthTimeout  time.Duration
+	reauthVerify   func(*User, string) bool
 }
 
-// NewChat initiate chat
-func NewChat(pool GopoolInterface) *Chat {
+// NewChat initiate chat. If pool is nil, every write runs in its own
+// goroutine.
+func NewChat(pool GopoolInterface, opts ...Option) *Chat {
 	chat := &Chat{
-		pool: pool,
-		ns:   make(map[string]*User),
-		out:  make(chan []byte, 1),
+		pool:    pool,
+		started: time.Now(),
+		ns:      make(map[string]*User),
+		as:      make(map[string]int),
+		ids:     make(map[string]*User),
+		ss:      make(map[string]session),
+		ts:      make(map[string]time.Time),
+		holds:   make(map[string]hold),
+		held:    make(map[string]string),
+		words:   append([]string(nil), animals[:]...),
+
+		handlers: make(map[string]handler),
+		tags:     make(map[string]map[string]map[*User]bool),
+		watchers: make(map[string]map[*User]bool),
+		high:     make(chan *message, 1),
+		outLock:  make(chan struct{}, 1),
+		highLock: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stop:     make(chan struct{}),
+
+		infoFields:   []string{"id", "name", "avatar", "spectator"},
+		pauseBuffer:  100,
+		maxInFlight:  4,
+		maxPins:      10,
+		queueSize:    256,
+		maxNack:      100,
+		drainTimeout: time.Second,
+
+		co: coalescer{
+			windows: make(map[string]time.Duration),
+			pending: make(map[string][]string),
+		},
+	}
+	for _, opt := range opts {
+		opt(chat)
+	}
+	chat.out = make(chan *message, chat.queueSize)
+	if chat.pool == nil {
+		chat.pool = goroutines{}
+	}
+	if chat.workers > 0 {
+		chat.ready = make(chan *User, readyQueue)
+		for i := 0; i < chat.workers; i++ {
+			go chat.worker()
+		}
 	}
 
 	go chat.writer()
+	if chat.probeSilence > 0 && chat.sweepInterval == 0 {
+		chat.sweepInterval = chat.probeTimeout
+	}
+	if (chat.idleTimeout > 0 || chat.probeSilence > 0) && chat.sweepInterval > 0 {
+		go chat.sweeper()
+	}
+	if chat.tickInterval > 0 {
+		go chat.ticker()
+	}
+	if chat.probeSilence > 0 {
+		go chat.prober()
+	}
 
 	return chat
 }
 
 // Register registers new connection as a User.
-func (c *Chat) Register(conn net.Conn) *User {
+// If chat is draining, connection is closed and ErrDraining is returned.
+// If chat is full, user is put in the waiting room or connection is closed
+// with ErrFull (see WithMaxUsers()).
+func (c *Chat) Register(conn net.Conn) (*User, error) {
+	return c.RegisterHandshake(conn, ws.Handshake{})
+}
+
+// RegisterHandshake registers new connection as a User. It stores
+// subprotocol negotiated during handshake.
+func (c *Chat) RegisterHandshake(conn net.Conn, hs ws.Handshake) (*User, error) {
+	return c.register(conn, hs)
+}
+
+func (c *Chat) register(conn io.ReadWriteCloser, hs ws.Handshake) (*User, error) {
 	user := &User{
 		chat: c,
 		conn: conn,
+
+		subprotocol: hs.Protocol,
+	}
+	if atomic.LoadUint32(&c.draining) == 1 {
+		user.closeFor(CloseDraining, "server draining")
+		atomic.AddUint64(&c.metrics.disconnects[CloseDraining], 1)
+		return nil, ErrDraining
 	}
+	if nc, ok := conn.(net.Conn); ok && c.keepAlive > 0 {
+		if err := setKeepAlive(nc, c.keepAlive); err != nil {
+			return nil, err
+		}
+	}
+	user.touch()
+	user.connectedAt = time.Now()
 
 	c.mu.Lock()
-	{
-		user.id = c.seq
-		user.name = c.randName()
+	if c.full() {
+		pos, err := c.enqueueWaiting(user)
+		c.mu.Unlock()
+		if err != nil {
+			user.closeFor(CloseFull, "server full")
+			atomic.AddUint64(&c.metrics.disconnects[CloseFull], 1)
+			return nil, err
+		}
+		user.writeNotice("queue_position", Object{"position": pos})
+		return user, nil
+	}
+	c.admit(user)
+	c.mu.Unlock()
 
-		c.us = append(c.us, user)
-		c.ns[user.name] = user
+	c.welcomeUser(user)
 
-		c.seq++
+	return user, nil
+}
+
+// admit adds user to chat.
+// mutex must be held.
+func (c *Chat) admit(user *User) {
+	user.id = c.seq
+	c.claimName(user, c.randName())
+	user.avatar = c.randAvatar()
+
+	c.us = append(c.us, user)
+	atomic.AddInt64(&c.connected, 1)
+
+	c.seq++
+}
+
+// welcomeUser sends "hello" notice to just admitted user and greets it.
+func (c *Chat) welcomeUser(user *User) {
+	hello := c.hello(user)
+	if c.storm != nil {
+		if d := c.storm.register(time.Now()); d > 0 {
+			hello["retryAfter"] = d.Milliseconds()
+		}
 	}
-	c.mu.Unlock()
+	user.writeNotice("hello", hello)
+	if pins := c.Pins(); len(pins) > 0 {
+		user.writeNotice("pins", Object{
+			"messages": pins,
+		})
+	}
+	user.startReauth()
+	user.startLifetime()
+	if c.greetPolicy == GreetAlways {
+		c.greet(user)
+	}
+	c.presence(user.name, true)
+}
 
-	user.writeNotice("hello", Object{
-		"name": user.name,
-	})
-	c.Broadcast("greet", Object{
-		"name": user.name,
-		"time": timestamp(),
+// Drain puts chat in draining state, when new connections are rejected but
+// existing users keep working. If downtime is not zero, users are notified
+// by "server_restarting" announcement with estimated downtime in
+// milliseconds.
+func (c *Chat) Drain(downtime time.Duration) error {
+	atomic.StoreUint32(&c.draining, 1)
+	if downtime == 0 {
+		return nil
+	}
+	return c.Announce("server_restarting", Object{
+		"downtime": downtime.Milliseconds(),
+		"time":     timestamp(),
 	})
-
-	return user
 }
 
 // Remove removes user from chat.
 func (c *Chat) Remove(user *User) {
+	if user.Waiting() && c.leaveWaiting(user) {
+		return
+	}
+
 	c.mu.Lock()
 	removed := c.remove(user)
+	greeted := user.greeted || c.greetPolicy == GreetAlways
 	c.mu.Unlock()
 
 	if !removed {
 		return
 	}
+	c.removed(user)
+	c.promote()
 
-	c.Broadcast("goodbye", Object{
+	if !greeted {
+		// Users which were never greeted leave silently too.
+		return
+	}
+	if c.roster.window > 0 {
+		c.rosterChange(user.name, false)
+		return
+	}
+	c.broadcastRoster("goodbye", Object{
 		"name": user.name,
 		"time": timestamp(),
 	})
@@ -117,66 +424,407 @@ func (c *Chat) Remove(user *User) {
 func (c *Chat) Rename(user *User, name string) (prev string, ok bool) {
 	c.mu.Lock()
 	{
-		if _, has := c.ns[name]; !has {
-			ok = true
-			prev, user.name = user.name, name
-			delete(c.ns, prev)
-			c.ns[name] = user
-		}
+		prev = user.name
+		ok = c.claimName(user, name)
 	}
 	c.mu.Unlock()
 
 	return prev, ok
 }
 
+// SetAuthenticated marks user as authenticated or anonymous one. Users are
+// anonymous by default.
+func (c *Chat) SetAuthenticated(u *User, authenticated bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ns[u.name] == u {
+		c.setAuthenticated(u, authenticated)
+	}
+}
+
+// mutex must be held.
+func (c *Chat) setAuthenticated(u *User, authenticated bool) {
+	if u.authenticated == authenticated {
+		return
+	}
+	u.authenticated = authenticated
+	if authenticated {
+		c.na++
+	} else {
+		c.na--
+	}
+}
+
+// SetSpectator makes user read-only spectator, who receives broadcasts but
+// could not publish, rename or send "typing", or promotes spectator to
+// participant. Admins could do the same with "spectate" request.
+func (c *Chat) SetSpectator(u *User, spectator bool) {
+	var v uint32
+	if spectator {
+		v = 1
+	}
+	atomic.StoreUint32(&u.spectator, v)
+}
+
+// AddNames adds words to the pool of random names.
+func (c *Chat) AddNames(words ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, w := range words {
+		if !hasWord(c.words, w) {
+			c.words = append(c.words, w)
+		}
+	}
+}
+
+// RemoveNames removes words from the pool of random names. Users which
+// already have names made of these words are not renamed.
+func (c *Chat) RemoveNames(words ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rest := c.words[:0]
+	for _, w := range c.words {
+		if !hasWord(words, w) {
+			rest = append(rest, w)
+		}
+	}
+	c.words = rest
+}
+
+// Names returns copy of the pool of random names.
+func (c *Chat) Names() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]string(nil), c.words...)
+}
+
+// Count returns number of users in chat.
+func (c *Chat) Count() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.us)
+}
+
+// UserInfo returns public information about user.
+// Set of returned fields could be configured with WithUserInfoFields().
+func (c *Chat) UserInfo(u *User) Object {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.userInfo(u)
+}
+
+// Users returns information about all users in chat.
+func (c *Chat) Users() []Object {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ret := make([]Object, len(c.us))
+	for i, u := range c.us {
+		ret[i] = c.userInfo(u)
+	}
+	return ret
+}
+
 // Broadcast sends message to all alive users.
+// Messages sent by Broadcast are delivered in the order of calls.
 func (c *Chat) Broadcast(method string, params Object) error {
-	var buf bytes.Buffer
+	return c.broadcast(c.out, target{}, method, params)
+}
 
-	w := wsutil.NewWriter(&buf, ws.StateServerSide, ws.OpText)
-	encoder := json.NewEncoder(w)
+// BroadcastWhere sends message to alive users for which pred returns true.
+// Note that pred is called from the writer goroutine.
+func (c *Chat) BroadcastWhere(pred func(*User) bool, method string, params Object) error {
+	return c.broadcast(c.out, target{where: pred}, method, params)
+}
 
-	r := Request{Method: method, Params: params}
-	if err := encoder.Encode(r); err != nil {
-		return err
+// Announce is like Broadcast but message is sent with high priority, that is,
+// it is written before messages sent by Broadcast which are not written yet.
+// Announcements are delivered in the order of calls, but there is no ordering
+// guarantee between announcements and normal broadcasts.
+func (c *Chat) Announce(method string, params Object) error {
+	return c.broadcast(c.high, target{}, method, params)
+}
+
+// broadcast sends message to users selected by target.
+//
+// Every broadcast gets next sequence number in the "seq" param. Sequence
+// numbers grow in the order of delivery within a priority level.
+func (c *Chat) broadcast(out chan *message, t target, method string, params Object) error {
+	_, err := c.broadcastSeq(context.Background(), out, t, method, params)
+	return err
+}
+
+// broadcastRoster broadcasts roster event, e.g. "greet" or "goodbye", to all
+// users. Roster events are not limited by the global rate, as shedding them
+// would leave client user lists out of sync.
+func (c *Chat) broadcastRoster(method string, params Object) error {
+	_, err := c.sequence(context.Background(), c.out, target{}, method, params)
+	return err
+}
+
+// broadcastSeq is like broadcast but also returns sequence number of the
+// message. Broadcast is traced as child of ctx.
+func (c *Chat) broadcastSeq(ctx context.Context, out chan *message, t target, method string, params Object) (uint64, error) {
+	if out != c.high {
+		// Announcements are not limited.
+		if err := c.limit(); err != nil {
+			return 0, err
+		}
 	}
-	if err := w.Flush(); err != nil {
+	return c.sequence(ctx, out, t, method, params)
+}
+
+// sequence queues message to the writer and assigns it next sequence number.
+func (c *Chat) sequence(ctx context.Context, out chan *message, t target, method string, params Object) (uint64, error) {
+	r := Request{Method: method, Params: make(Object, len(params)+1)}
+	for k, v := range params {
+		r.Params[k] = v
+	}
+
+	_, end := c.traceStart(ctx, method, &r)
+	defer end()
+
+	if c.shuttingDown() {
+		// Writer exits once Shutdown flushes, so nobody would dequeue it.
+		return 0, ErrShuttingDown
+	}
+	// Message is queued before it is sequenced and framed, so sequence
+	// numbers have no gaps and no lock is held while waiting for the writer.
+	// Writer waits for the message to be ready.
+	m := &message{target: t, ready: make(chan struct{})}
+	c.flight.add(1)
+	lock, ok := c.enqueueBroadcast(out, m)
+	if !ok {
+		c.flight.done()
+		return 0, ErrBusy
+	}
+	defer func() { <-lock }()
+	defer close(m.ready)
+
+	c.bmu.Lock()
+	defer c.bmu.Unlock()
+
+	seq := c.bseq + 1
+	r.Params["seq"] = seq
+	framed, err := c.newMessage(r)
+	if err != nil {
+		// Writer skips messages without frames.
+		return 0, err
+	}
+	m.v1, m.v2 = framed.v1, framed.v2
+	m.seq = seq
+	m.req = r
+
+	c.bseq++
+	c.h.append(entry{
+		seq:    seq,
+		req:    r,
+		target: t,
+	})
+	atomic.AddUint64(&c.metrics.broadcasts, 1)
+
+	return seq, nil
+}
+
+// SendTo sends notice to user with given name. If there is no such user, it
+// returns ErrRecentlyLeft when user left within tombstone TTL (see
+// WithTombstoneTTL()), and ErrNoSuchUser otherwise.
+// ErrSlowRecipient is returned when recipient is too slow (see
+// WithSlowClient()).
+func (c *Chat) SendTo(name, method string, params Object) error {
+	return c.sendTo(nil, name, method, params)
+}
+
+// sendTo sends notice on behalf of given user. If recipient blocked the
+// sender, notice is silently dropped.
+func (c *Chat) sendTo(from *User, name, method string, params Object) error {
+	user, has := c.Lookup(name)
+	if !has && c.recentlyLeft(name) {
+		return ErrRecentlyLeft
+	}
+	if !has {
+		return ErrNoSuchUser
+	}
+	if from != nil && user.blocks(from) {
+		return nil
+	}
+	if c.slow(user) {
+		return ErrSlowRecipient
+	}
+
+	return user.writeNotice(method, params)
+}
+
+// Lookup returns user with given name.
+func (c *Chat) Lookup(name string) (*User, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	user, has := c.ns[name]
+	return user, has
+}
+
+// KickAll disconnects all users with given reason, including ones in the
+// waiting room. Users are notified by single "system" notice before
+// disconnect.
+func (c *Chat) KickAll(reason string) error {
+	c.mu.Lock()
+	us := append(c.us[:len(c.us):len(c.us)], c.wait...)
+	// Waiting room is emptied at once, so slots freed by the kick do not
+	// admit anyone from it.
+	c.wait = nil
+	c.mu.Unlock()
+
+	return c.kick(us, reason)
+}
+
+// kick sends "system" notice to given users and closes their connections.
+// Users are removed from chat without "goodbye" broadcast.
+func (c *Chat) kick(us []*User, reason string) error {
+	m, err := c.newMessage(Request{Method: "system", Params: Object{
+		"text": reason,
+		"time": timestamp(),
+	}})
+	if err != nil {
 		return err
 	}
+	// Let already scheduled writes reach users before the notice. All
+	// users share the same drain deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), c.drainTimeout)
+	defer cancel()
+	for _, u := range us {
+		u.drain(ctx)
+		u.writeRaw(m.frame(u.Protocol()))
+		u.closeFor(CloseKicked, reason)
+	}
 
-	c.out <- buf.Bytes()
+	removed := make([]*User, 0, len(us))
+	c.mu.Lock()
+	for _, u := range us {
+		if c.remove(u) {
+			removed = append(removed, u)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, u := range removed {
+		c.removed(u)
+	}
+	c.promote()
 
 	return nil
 }
 
-// writer writes broadcast messages from chat.out channel.
+// hello returns params of the "hello" notice for given user.
+// It always contains id and name of the user.
+func (c *Chat) hello(user *User) Object {
+	params := Object{}
+	if c.welcome != nil {
+		for k, v := range c.welcome(user) {
+			params[k] = v
+		}
+	}
+	params["id"] = user.id
+	params["name"] = user.name
+
+	return withAvatar(user, params)
+}
+
+// writer writes broadcast messages from chat.high and chat.out channels.
+// Messages from chat.high are written first. It exits on Shutdown.
 func (c *Chat) writer() {
-	for bts := range c.out {
-		c.mu.RLock()
-		us := c.us
-		c.mu.RUnlock()
+	for {
+		var m *message
+		select {
+		case m = <-c.high:
+		default:
+			select {
+			case m = <-c.high:
+			case m = <-c.out:
+			case <-c.stop:
+				return
+			}
+		}
+		if m.ready != nil {
+			<-m.ready
+		}
+		if m.v1 == nil {
+			// Message could not be framed.
+			c.flight.done()
+			continue
+		}
+
+		us := m.to
+		if us == nil {
+			c.mu.RLock()
+			us = c.us
+			c.mu.RUnlock()
+		}
 
 		for _, u := range us {
-			u := u // For closure.
-			c.pool.Schedule(func() {
-				u.writeRaw(bts)
-			})
+			if !m.allows(u) || u.paused() && u.buffer(c.frameFor(*m, u), m.seq) {
+				continue
+			}
+			c.deliver(m, u)
 		}
+		c.flight.done()
+	}
+}
+
+// schedule schedules task according to the chat schedule policy.
+// It returns false if task was dropped.
+func (c *Chat) schedule(task func()) bool {
+	if c.policy == ScheduleBlock {
+		c.pool.Schedule(task)
+		return true
+	}
+	if err := c.pool.ScheduleTimeout(c.scheduleTimeout, task); err == nil {
+		return true
+	}
+	if c.policy == ScheduleInline {
+		task()
+		return true
 	}
+	atomic.AddUint64(&c.dropped, 1)
+	return false
 }
 
 // mutex must be held.
 func (c *Chat) remove(user *User) bool {
-	if _, has := c.ns[user.name]; !has {
+	if c.ns[user.name] != user {
+		// Already removed. Name could be taken by another user since then,
+		// e.g. by the one which took the session over.
 		return false
 	}
 
 	delete(c.ns, user.name)
+	c.bury(user)
+	c.holdName(user)
+	c.releaseAvatar(user)
+	for key := range user.tags {
+		c.untag(user, key)
+	}
+	c.unwatch(user)
+	user.stopReauth()
+	user.stopLifetime()
+	if user.authenticated {
+		c.na--
+	}
+	if c.ids[user.identity] == user {
+		delete(c.ids, user.identity)
+		c.saveSession(user)
+	}
 
 	i := sort.Search(len(c.us), func(i int) bool {
 		return c.us[i].id >= user.id
 	})
-	if i >= len(c.us) {
+	if i >= len(c.us) || c.us[i] != user {
 		panic("chat: inconsistent state")
 	}
 
@@ -184,20 +832,124 @@ func (c *Chat) remove(user *User) bool {
 	copy(without[:i], c.us[:i])
 	copy(without[i:], c.us[i+1:])
 	c.us = without
+	atomic.AddInt64(&c.connected, -1)
+	c.countDisconnect(user)
 
 	return true
 }
 
+// mutex must be held.
+func (c *Chat) userInfo(u *User) Object {
+	info := Object{}
+	for _, f := range c.infoFields {
+		switch f {
+		case "id":
+			info[f] = u.id
+		case "name":
+			info[f] = u.name
+		case "avatar":
+			if u.avatar != "" {
+				info[f] = u.avatar
+			}
+		case "spectator":
+			info[f] = u.Spectator()
+		}
+	}
+	return info
+}
+
+// textTooLong reports whether "text" or "body" param is longer than maximum
+// configured with WithMaxTextLen(). Length is measured in runes.
+func (c *Chat) textTooLong(params Object) bool {
+	if c.maxTextLen <= 0 {
+		return false
+	}
+	for _, key := range [...]string{"text", "body"} {
+		if s, ok := params.GetString(key); ok && utf8.RuneCountInString(s) > c.maxTextLen {
+			return true
+		}
+	}
+	return false
+}
+
+// claimName assigns name to user if it is not taken by someone else.
+// It is the only place where user names are changed, so there is no window
+// when name is free for randName() but is being taken by Rename().
+// mutex must be held.
+func (c *Chat) claimName(user *User, name string) bool {
+	if _, has := c.ns[name]; has {
+		return false
+	}
+	if c.reserved(user, name) {
+		return false
+	}
+	if c.ns[user.name] == user {
+		delete(c.ns, user.name)
+	}
+	user.name = name
+	c.ns[name] = user
+
+	return true
+}
+
+// mutex must be held.
 func (c *Chat) randName() string {
 	var suffix string
-	for {
-		name := animals[rand.Intn(len(animals))] + suffix
-		if _, has := c.ns[name]; !has {
+	for attempt := 0; ; attempt++ {
+		base := "user"
+		if len(c.words) > 0 {
+			base = c.words[rand.Intn(len(c.words))]
+		}
+		if c.formatName != nil {
+			base = c.formatName(base)
+		}
+		name := base + suffix
+		if c.suffix != nil && attempt > 0 {
+			name = c.suffix(base, attempt)
+		}
+		_, taken := c.ns[name]
+		if _, held := c.holds[name]; !taken && !held {
 			return name
 		}
 		suffix += strconv.Itoa(rand.Intn(10))
 	}
-	return ""
+}
+
+// frame encodes x as a single websocket text frame.
+func frame(x interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := wsutil.NewWriter(&buf, ws.StateServerSide, ws.OpText)
+	encoder := json.NewEncoder(w)
+
+	if err := encoder.Encode(x); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func hasWord(words []string, w string) bool {
+	for _, v := range words {
+		if v == w {
+			return true
+		}
+	}
+	return false
+}
+
+// TitleCase returns name with first letter of each word in upper case, e.g.
+// "Polar Bear". It could be passed to WithNameFormat().
+func TitleCase(name string) string {
+	words := strings.Fields(name)
+	for i, w := range words {
+		r, n := utf8.DecodeRuneInString(w)
+		words[i] = string(unicode.ToUpper(r)) + w[n:]
+	}
+	return strings.Join(words, " ")
 }
 
 func timestamp() int64 {
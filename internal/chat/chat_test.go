@@ -0,0 +1,76 @@
+package chat_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/suryatresna/multiplayerengine/internal/chat"
+)
+
+func TestAnnounceOvertakesStalledQueue(t *testing.T) {
+	c := chat.NewChat(nil,
+		chat.WithOrdering(chat.OrderStrictGlobal),
+		chat.WithBroadcastQueue(1, 0),
+	)
+	p := connect(t, c)
+	flush(t, c)
+
+	// Writer blocks writing "a", "b" fills the queue and "c" waits for it.
+	blocked, release := p.conn.stall()
+	defer release()
+	if err := c.Broadcast("a", nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Fatal("writer is not blocked")
+	}
+	if err := c.Broadcast("b", nil); err != nil {
+		t.Fatal(err)
+	}
+	go c.Broadcast("c", nil)
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Announce("x", nil)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Announce is blocked by stalled queue")
+	}
+	release()
+
+	var got []string
+	for len(got) < 4 {
+		select {
+		case req := <-p.cl.Subscribe():
+			switch req.Method {
+			case "a", "b", "c", "x":
+				got = append(got, req.Method)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("received %v; want 4 broadcasts", got)
+		}
+	}
+	if want := []string{"a", "x", "b", "c"}; !equal(got, want) {
+		t.Errorf("received %v; want %v", got, want)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
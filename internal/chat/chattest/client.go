@@ -0,0 +1,224 @@
+// Package chattest provides minimal chat client for end-to-end testing of
+// the chat server. It is not intended for production use, but also
+// documents the wire protocol: client sends json-rpc requests in text
+// frames, server replies with responses carrying the same id and sends
+// notices (requests without id) at any time.
+package chattest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/suryatresna/multiplayerengine/internal/chat"
+)
+
+// ErrClosed returned by Call when connection is closed before response is
+// received.
+var ErrClosed = fmt.Errorf("chattest: connection closed")
+
+// CallError is returned by Call when server replies with error response.
+type CallError struct {
+	Message string
+	Code    int
+}
+
+func (e *CallError) Error() string {
+	return fmt.Sprintf("chattest: %s (code %d)", e.Message, e.Code)
+}
+
+// reply is any message sent by server.
+type reply struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params chat.Object     `json:"params"`
+	Result chat.Object     `json:"result"`
+	Error  chat.Object     `json:"error"`
+}
+
+// Client is a chat client speaking ProtocolV1.
+type Client struct {
+	conn net.Conn
+
+	mu    sync.Mutex
+	seq   int
+	calls map[string]chan reply
+	err   error
+
+	wmu     sync.Mutex
+	notices chan chat.Request
+}
+
+// Dial connects to the chat server at given websocket url, e.g.
+// "ws://localhost:8000/".
+func Dial(ctx context.Context, url string) (*Client, error) {
+	conn, _, _, err := ws.Dial(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns client speaking over established websocket connection,
+// e.g. end of net.Pipe() which other end is passed to Chat.RegisterConn().
+func NewClient(conn net.Conn) *Client {
+	c := &Client{
+		conn:    conn,
+		calls:   make(map[string]chan reply),
+		notices: make(chan chat.Request, 64),
+	}
+	go c.reader()
+	return c
+}
+
+// Call sends request and waits for its response until ctx is done. Methods
+// which have no response, e.g. "ack", must be sent with Notify() instead,
+// otherwise Call returns ctx error.
+func (c *Client) Call(ctx context.Context, method string, params chat.Object) (chat.Response, error) {
+	ch := make(chan reply, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return chat.Response{}, c.err
+	}
+	c.seq++
+	id := strconv.Itoa(c.seq)
+	c.calls[id] = ch
+	c.mu.Unlock()
+
+	if err := c.send(chat.Request{
+		ID:     json.RawMessage(id),
+		Method: method,
+		Params: params,
+	}); err != nil {
+		c.mu.Lock()
+		delete(c.calls, id)
+		c.mu.Unlock()
+		return chat.Response{}, err
+	}
+
+	var (
+		r  reply
+		ok bool
+	)
+	select {
+	case r, ok = <-ch:
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.calls, id)
+		c.mu.Unlock()
+		return chat.Response{}, ctx.Err()
+	}
+	if !ok {
+		return chat.Response{}, c.Err()
+	}
+	resp := chat.Response{
+		ID:     r.ID,
+		Result: r.Result,
+	}
+	if r.Error != nil {
+		msg, _ := r.Error.GetString("error")
+		code, _ := r.Error.GetInt("code")
+		return resp, &CallError{
+			Message: msg,
+			Code:    code,
+		}
+	}
+	return resp, nil
+}
+
+// Notify sends notice which has no response.
+func (c *Client) Notify(method string, params chat.Object) error {
+	return c.send(chat.Request{
+		Method: method,
+		Params: params,
+	})
+}
+
+// Subscribe returns channel of notices sent by server, e.g. "hello" or
+// "publish". Channel is closed when connection is closed. Notices are
+// dropped if channel is not read fast enough.
+func (c *Client) Subscribe() <-chan chat.Request {
+	return c.notices
+}
+
+// Err returns error which caused connection to close.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close closes connection.
+func (c *Client) Close() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	ws.WriteFrame(c.conn, ws.MaskFrame(ws.NewCloseFrame(ws.NewCloseFrameBody(
+		ws.StatusNormalClosure, "",
+	))))
+	return c.conn.Close()
+}
+
+func (c *Client) send(req chat.Request) error {
+	bts, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	return wsutil.WriteClientText(c.conn, bts)
+}
+
+func (c *Client) reader() {
+	var err error
+	for {
+		var p []byte
+		p, _, err = wsutil.ReadServerData(c.conn)
+		if err != nil {
+			break
+		}
+		var r reply
+		if err = json.Unmarshal(p, &r); err != nil {
+			break
+		}
+		if r.Method != "" {
+			select {
+			case c.notices <- chat.Request{Method: r.Method, Params: r.Params}:
+			default:
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		ch := c.calls[string(r.ID)]
+		delete(c.calls, string(r.ID))
+		c.mu.Unlock()
+
+		if ch != nil {
+			ch <- r
+		}
+	}
+
+	c.mu.Lock()
+	c.err = err
+	if _, ok := err.(wsutil.ClosedError); ok || err == io.EOF {
+		c.err = ErrClosed
+	}
+	for id, ch := range c.calls {
+		close(ch)
+		delete(c.calls, id)
+	}
+	c.mu.Unlock()
+
+	close(c.notices)
+}
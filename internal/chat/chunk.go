@@ -0,0 +1,30 @@
+package chat
+
+// writeChunks writes already framed p to connection by chunks of given size.
+// Each chunk gets its own write deadline, so stalled client is detected
+// without waiting for the whole frame. It returns number of bytes written.
+// io mutex must be held.
+func (u *User) writeChunks(p []byte, size int) (int, error) {
+	d := u.chat.chunkTimeout
+	if d == 0 {
+		d = u.chat.writeTimeout
+	}
+	var total int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > size {
+			chunk = chunk[:size]
+		}
+		if err := u.setWriteDeadline(d); err != nil {
+			return total, err
+		}
+		n, err := u.conn.Write(chunk)
+		u.written(n)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
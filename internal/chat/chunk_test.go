@@ -0,0 +1,75 @@
+package chat
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws/wsutil"
+)
+
+// chunkConn records writes and deadlines. It writes at most max bytes at once.
+type chunkConn struct {
+	recordConn
+	max       int
+	writes    []int
+	deadlines int
+}
+
+func (c *chunkConn) Write(p []byte) (int, error) {
+	if len(p) > c.max {
+		p = p[:c.max]
+	}
+	c.writes = append(c.writes, len(p))
+	return c.recordConn.Write(p)
+}
+
+func (c *chunkConn) SetWriteDeadline(time.Time) error {
+	c.deadlines++
+	return nil
+}
+
+func TestWriteChunks(t *testing.T) {
+	text := strings.Repeat("x", 100)
+	for _, test := range []struct {
+		size int
+		max  int
+	}{
+		{size: 1, max: 1},
+		{size: 3, max: 8},
+		{size: 7, max: 2},
+	} {
+		conn := &chunkConn{max: test.max}
+		u := &User{
+			chat: NewChat(nil, WithWriteChunks(test.size, time.Second)),
+			conn: conn,
+		}
+		if err := u.write(Object{"text": text}); err != nil {
+			t.Fatal(err)
+		}
+		total := 0
+		for _, n := range conn.writes {
+			if n > test.size {
+				t.Errorf("size %d: wrote %d bytes at once", test.size, n)
+			}
+			total += n
+		}
+		if conn.deadlines != len(conn.writes) {
+			t.Errorf("size %d: %d deadlines for %d writes", test.size, conn.deadlines, len(conn.writes))
+		}
+
+		size := conn.Len()
+		p, _, err := wsutil.ReadServerData(&conn.Buffer)
+		if err != nil {
+			t.Fatalf("size %d: read frame: %v", test.size, err)
+		}
+		var obj Object
+		if err := json.Unmarshal(p, &obj); err != nil || obj["text"] != text {
+			t.Errorf("size %d: written %q; error %v", test.size, p, err)
+		}
+		if total != size || conn.Len() != 0 {
+			t.Errorf("size %d: wrote %d bytes of %d byte frame", test.size, total, size)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package chat
+
+import (
+	"sync/atomic"
+
+	"github.com/gobwas/ws"
+)
+
+// Close codes sent in close frames of server-side disconnects, so clients
+// could decide whether to reconnect. Codes in 4000-4999 range are private
+// to the application.
+const (
+	// CloseCodeKicked means that user was kicked; client should not
+	// reconnect automatically.
+	CloseCodeKicked ws.StatusCode = 4000
+	// CloseCodeIdle means that user was idle for too long; client could
+	// reconnect when user becomes active.
+	CloseCodeIdle ws.StatusCode = 4001
+	// CloseCodeReauth means that re-authentication failed; client should
+	// obtain fresh credentials before reconnect.
+	CloseCodeReauth ws.StatusCode = 4002
+	// CloseCodeTakeover means that the same identity connected elsewhere;
+	// client should not reconnect.
+	CloseCodeTakeover ws.StatusCode = 4003
+	// CloseCodeLifetime means that connection reached maximum lifetime;
+	// client should reconnect immediately.
+	CloseCodeLifetime ws.StatusCode = 4004
+	// CloseCodeRate means that user exceeded rate limits; client should
+	// back off before reconnect.
+	CloseCodeRate ws.StatusCode = 4005
+	// CloseCodeFull means that chat and its waiting room are full; client
+	// should retry later.
+	CloseCodeFull ws.StatusCode = 4006
+	// CloseCodeDraining means that server is going away; client should
+	// reconnect later, possibly to another node.
+	CloseCodeDraining = ws.StatusGoingAway
+)
+
+// CloseReason is a reason of user disconnect.
+type CloseReason uint32
+
+const (
+	// CloseLeft means that client closed connection or it was broken.
+	CloseLeft CloseReason = iota
+	CloseKicked
+	CloseIdle
+	CloseReauth
+	CloseTakeover
+	// CloseWriteError means that write to connection failed, so no close
+	// frame is sent.
+	CloseWriteError
+	// CloseDraining means that server is going away: connection was
+	// rejected by draining chat or closed by Shutdown.
+	CloseDraining
+	CloseLifetime
+	CloseRate
+	// CloseSlow means that recipient of direct message had too many pending
+	// writes, so no close frame is sent.
+	CloseSlow
+	// CloseFull means that connection was rejected by full chat.
+	CloseFull
+	// CloseProbe means that client did not answer read probe, so no close
+	// frame is sent.
+	CloseProbe
+
+	numCloseReasons
+)
+
+var closeReasons = [numCloseReasons]struct {
+	name string
+	code ws.StatusCode
+}{
+	CloseLeft:       {"left", ws.StatusNormalClosure},
+	CloseKicked:     {"kicked", CloseCodeKicked},
+	CloseIdle:       {"idle", CloseCodeIdle},
+	CloseReauth:     {"reauth", CloseCodeReauth},
+	CloseTakeover:   {"takeover", CloseCodeTakeover},
+	CloseWriteError: {"write_error", ws.StatusAbnormalClosure},
+	CloseDraining:   {"draining", CloseCodeDraining},
+	CloseLifetime:   {"lifetime", CloseCodeLifetime},
+	CloseRate:       {"rate", CloseCodeRate},
+	CloseSlow:       {"slow", ws.StatusAbnormalClosure},
+	CloseFull:       {"full", CloseCodeFull},
+	CloseProbe:      {"probe", ws.StatusAbnormalClosure},
+}
+
+// Code returns close code sent for the reason.
+func (r CloseReason) Code() ws.StatusCode {
+	return closeReasons[r].code
+}
+
+func (r CloseReason) String() string {
+	return closeReasons[r].name
+}
+
+// CloseReason returns reason of user disconnect. It is CloseLeft until
+// server disconnects user.
+func (u *User) CloseReason() CloseReason {
+	return CloseReason(atomic.LoadUint32(&u.disconnect))
+}
+
+// setCloseReason sets reason of the upcoming disconnect of user. Only the
+// first reason is kept.
+func (u *User) setCloseReason(r CloseReason) {
+	atomic.CompareAndSwapUint32(&u.disconnect, 0, uint32(r))
+}
+
+// closeFor sends close frame with code of given reason and text and closes
+// connection.
+func (u *User) closeFor(r CloseReason, text string) error {
+	u.setCloseReason(r)
+	return u.close(r.Code(), text)
+}
+
+// removed calls remove hook for removed user.
+func (c *Chat) removed(u *User) {
+	if c.onRemove != nil {
+		c.onRemove(u, u.CloseReason())
+	}
+	c.presence(u.name, false)
+}
@@ -0,0 +1,61 @@
+package chat
+
+import (
+	"sync"
+	"time"
+)
+
+// coalescer collects values of high-frequency events and broadcasts them
+// once per window.
+type coalescer struct {
+	mu      sync.Mutex
+	windows map[string]time.Duration
+	pending map[string][]string
+}
+
+// BroadcastCoalesced broadcasts value with given method. If coalescing is
+// enabled for method with WithCoalesce(), values collected during the window
+// are sent as single broadcast with params {method: [values...]}. Otherwise
+// broadcast is sent immediately.
+func (c *Chat) BroadcastCoalesced(method, value string) error {
+	c.co.mu.Lock()
+	window, has := c.co.windows[method]
+	if !has {
+		c.co.mu.Unlock()
+		return c.Broadcast(method, Object{
+			method: []string{value},
+			"time": timestamp(),
+		})
+	}
+	values, scheduled := c.co.pending[method]
+	c.co.pending[method] = appendUnique(values, value)
+	c.co.mu.Unlock()
+
+	if !scheduled {
+		time.AfterFunc(window, func() {
+			c.flushCoalesced(method)
+		})
+	}
+	return nil
+}
+
+func (c *Chat) flushCoalesced(method string) {
+	c.co.mu.Lock()
+	values := c.co.pending[method]
+	delete(c.co.pending, method)
+	c.co.mu.Unlock()
+
+	c.Broadcast(method, Object{
+		method: values,
+		"time": timestamp(),
+	})
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
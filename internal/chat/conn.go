@@ -0,0 +1,46 @@
+package chat
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/gobwas/ws"
+)
+
+// RegisterConn is like Register but accepts any connection, e.g. in-memory
+// pipe in tests or wrapper throttling or logging traffic. Connection must
+// speak websocket protocol as seen from server side. Options which require
+// net.Conn, such as WithKeepAlive(), are ignored for connections which are
+// not net.Conn.
+func (c *Chat) RegisterConn(conn io.ReadWriteCloser) (*User, error) {
+	return c.register(conn, ws.Handshake{})
+}
+
+// goroutines is a pool used when NewChat is called with nil pool. It runs
+// every task in a new goroutine.
+type goroutines struct{}
+
+var errNoPool = fmt.Errorf("chat: no pool")
+
+func (goroutines) Schedule(task func()) {
+	go task()
+}
+
+func (goroutines) ScheduleTimeout(_ time.Duration, task func()) error {
+	go task()
+	return nil
+}
+
+func (goroutines) Add(net.Conn) error {
+	return errNoPool
+}
+
+func (goroutines) Remove(net.Conn) error {
+	return errNoPool
+}
+
+func (goroutines) Wait() ([]net.Conn, error) {
+	return nil, errNoPool
+}
@@ -0,0 +1,160 @@
+package chat_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/suryatresna/multiplayerengine/internal/chat"
+	"github.com/suryatresna/multiplayerengine/internal/chat/chattest"
+)
+
+// pipeConn is server end of in-memory connection. Writes block while
+// connection is stalled.
+type pipeConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	mu      sync.Mutex
+	gate    chan struct{}
+	blocked chan struct{}
+}
+
+func (c *pipeConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *pipeConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	gate, blocked := c.gate, c.blocked
+	c.blocked = nil
+	c.mu.Unlock()
+
+	if gate != nil {
+		if blocked != nil {
+			close(blocked)
+		}
+		<-gate
+	}
+	return c.Conn.Write(p)
+}
+
+// stall makes writes block until release is called. Returned channel is
+// closed when the first write blocks.
+func (c *pipeConn) stall() (blocked <-chan struct{}, release func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	gate := make(chan struct{})
+	c.gate = gate
+	c.blocked = make(chan struct{})
+	var once sync.Once
+	return c.blocked, func() {
+		once.Do(func() {
+			c.mu.Lock()
+			c.gate = nil
+			c.mu.Unlock()
+			close(gate)
+		})
+	}
+}
+
+type peer struct {
+	u    *chat.User
+	cl   *chattest.Client
+	conn *pipeConn
+	// raw is client end of connection for writing frames directly.
+	raw net.Conn
+}
+
+// connect registers client connected over in-memory pipe. User is served as
+// with epoll: Receive is called only when client data is available, so
+// writes are not blocked by pending read.
+func connect(t *testing.T, c *chat.Chat) peer {
+	t.Helper()
+
+	srv, cli := net.Pipe()
+	conn := &pipeConn{Conn: srv, r: bufio.NewReader(srv)}
+	cl := chattest.NewClient(cli)
+	u, err := c.RegisterConn(conn)
+	if err != nil {
+		t.Fatalf("RegisterConn() error: %v", err)
+	}
+	go func() {
+		for {
+			if _, err := conn.r.Peek(1); err != nil {
+				break
+			}
+			if err := u.Receive(); err != nil {
+				break
+			}
+		}
+		c.Remove(u)
+	}()
+	t.Cleanup(func() {
+		cl.Close()
+	})
+	return peer{u, cl, conn, cli}
+}
+
+// expect waits for notice with given method, skipping others.
+func expect(t *testing.T, cl *chattest.Client, method string) chat.Request {
+	t.Helper()
+
+	tm := time.NewTimer(time.Second)
+	defer tm.Stop()
+	for {
+		select {
+		case req, ok := <-cl.Subscribe():
+			if !ok {
+				t.Fatalf("connection closed waiting for %q: %v", method, cl.Err())
+			}
+			if req.Method == method {
+				return req
+			}
+		case <-tm.C:
+			t.Fatalf("no %q notice received", method)
+		}
+	}
+}
+
+// callContext returns context for chattest.Client.Call, so test fails
+// instead of blocking when no response is sent.
+func callContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func flush(t testing.TB, c *chat.Chat) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := c.Flush(ctx); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+}
+
+func TestRegisterConn(t *testing.T) {
+	c := chat.NewChat(nil)
+	a := connect(t, c)
+	b := connect(t, c)
+
+	// Greets of a itself and of b could come in any order.
+	name := c.UserInfo(b.u)["name"]
+	for i := 0; i < 2; i++ {
+		if expect(t, a.cl, "greet").Params["name"] == name {
+			break
+		}
+		if i == 1 {
+			t.Errorf("no greet of %v received", name)
+		}
+	}
+	if _, err := b.cl.Call(callContext(t), "whoami", nil); err != nil {
+		t.Errorf("whoami error: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package chat
+
+import (
+	"context"
+	"time"
+)
+
+// requestTimeout returns timeout given by "deadline" param of req, which is
+// number of milliseconds from now.
+func requestTimeout(req *Request) (time.Duration, bool) {
+	ms, ok := req.Params.GetFloat("deadline")
+	if !ok || ms <= 0 {
+		return 0, false
+	}
+	return time.Duration(ms * float64(time.Millisecond)), true
+}
+
+// withRequestDeadline returns ctx with deadline given by "deadline" param of
+// req, if any. It is applied to every request, so handlers registered by
+// Handle() could stop early too.
+func withRequestDeadline(ctx context.Context, req *Request) (context.Context, context.CancelFunc) {
+	timeout, ok := requestTimeout(req)
+	if !ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// writeQueryResultTo writes result of fn as response to req.
+// If req has "deadline" param, fn is executed over chat pool and
+// ErrCodeTimeout error is written if result is not ready until deadline of
+// ctx.
+func (u *User) writeQueryResultTo(ctx context.Context, req *Request, fn func() (Object, error)) error {
+	timeout, ok := requestTimeout(req)
+	if !ok {
+		result, err := fn()
+		return u.writeHandlerResultTo(req, result, err)
+	}
+	type result struct {
+		obj Object
+		err error
+	}
+	res := make(chan result, 1)
+	err := u.chat.pool.ScheduleTimeout(timeout, func() {
+		if ctx.Err() == nil {
+			obj, err := fn()
+			res <- result{obj, err}
+		}
+	})
+	if err == nil {
+		select {
+		case r := <-res:
+			return u.writeHandlerResultTo(req, r.obj, r.err)
+		case <-ctx.Done():
+		}
+	}
+	return u.writeErrorTo(req, Object{
+		"error": "timeout",
+		"code":  ErrCodeTimeout,
+	})
+}
@@ -0,0 +1,36 @@
+package chat
+
+import "fmt"
+
+// ErrTooDeep returned by readRequest when request json is nested deeper than
+// limit set by WithMaxDepth().
+var ErrTooDeep = fmt.Errorf("chat: request is nested too deep")
+
+// tooDeep reports whether json objects and arrays in p are nested deeper
+// than max. It does not validate json.
+func tooDeep(p []byte, max int) bool {
+	var (
+		depth  int
+		str    bool
+		escape bool
+	)
+	for _, b := range p {
+		switch {
+		case escape:
+			escape = false
+		case str && b == '\\':
+			escape = true
+		case b == '"':
+			str = !str
+		case str:
+		case b == '{' || b == '[':
+			depth++
+			if depth > max {
+				return true
+			}
+		case b == '}' || b == ']':
+			depth--
+		}
+	}
+	return false
+}
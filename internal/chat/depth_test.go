@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gobwas/ws/wsutil"
+)
+
+// replyConn reads from r and records writes.
+type replyConn struct {
+	recordConn
+	r io.Reader
+}
+
+func (c *replyConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func TestTooDeep(t *testing.T) {
+	for _, test := range []struct {
+		json string
+		max  int
+		exp  bool
+	}{
+		{`{"a":[1,{"b":2}]}`, 3, false},
+		{`{"a":[1,{"b":2}]}`, 2, true},
+		{`[[],[],[]]`, 2, false},
+		{`{"a":"[[[[{{{{"}`, 1, false},
+		{`{"a":"\"[[[["}`, 1, false},
+		{`{"a":"\\","b":[[]]}`, 2, true},
+	} {
+		if act := tooDeep([]byte(test.json), test.max); act != test.exp {
+			t.Errorf("tooDeep(%s, %d) = %t; want %t", test.json, test.max, act, test.exp)
+		}
+	}
+}
+
+func TestReceiveTooDeep(t *testing.T) {
+	const depth = 100000
+	payload := `{"method":"publish","params":{"x":` +
+		strings.Repeat("[", depth) + strings.Repeat("]", depth) + `}}`
+
+	var stream bytes.Buffer
+	stream.Write(clientFrames([]byte(payload), 4))
+	stream.Write(clientFrames([]byte(`{"method":"typing"}`), 1))
+	conn := &replyConn{r: &stream}
+	u := &User{chat: NewChat(nil, WithMaxDepth(32)), conn: conn}
+
+	if err := u.Receive(); err != nil {
+		t.Fatalf("Receive() error: %v", err)
+	}
+	p, _, err := wsutil.ReadServerData(&conn.Buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp Error
+	if err := json.Unmarshal(p, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := resp.Error["code"].(float64); int(code) != ErrCodeParse {
+		t.Errorf("error response %s; want code %d", p, ErrCodeParse)
+	}
+	// Rejected message is consumed entirely.
+	req, err := u.readRequest()
+	if err != nil || req.Method != "typing" {
+		t.Errorf("next request %v, error %v; want typing", req, err)
+	}
+}
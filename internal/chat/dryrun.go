@@ -0,0 +1,42 @@
+package chat
+
+// BroadcastWhereDryRun returns names of users who would receive message
+// sent by BroadcastWhere() with the same pred. Nothing is sent.
+func (c *Chat) BroadcastWhereDryRun(pred func(*User) bool) []string {
+	return c.recipients(target{where: pred})
+}
+
+// BroadcastTagDryRun returns names of users who would receive message sent
+// by BroadcastTag() with the same key and value. Nothing is sent.
+func (c *Chat) BroadcastTagDryRun(key, value string) []string {
+	c.mu.RLock()
+	us := make([]*User, 0, len(c.tags[key][value]))
+	for u := range c.tags[key][value] {
+		us = append(us, u)
+	}
+	c.mu.RUnlock()
+
+	if len(us) == 0 {
+		return nil
+	}
+	return c.recipients(target{to: us})
+}
+
+// recipients returns names of current users selected by target.
+func (c *Chat) recipients(t target) []string {
+	c.mu.RLock()
+	us := c.us
+	names := make([]string, len(us))
+	for i, u := range us {
+		names[i] = u.name
+	}
+	c.mu.RUnlock()
+
+	var ret []string
+	for i, u := range us {
+		if t.deliversTo(u) {
+			ret = append(ret, names[i])
+		}
+	}
+	return ret
+}
@@ -0,0 +1,19 @@
+package chat
+
+// Error codes sent in the "code" field of error responses.
+const (
+	// ErrCodeParse means that request could not be parsed.
+	ErrCodeParse = -32700
+	// ErrCodeInvalidParams means that request params are invalid.
+	ErrCodeInvalidParams = -32602
+	// ErrCodeForbidden means that user is not allowed to call the method.
+	ErrCodeForbidden = -32003
+
+	// ErrCodeBusy means that server is overloaded.
+	ErrCodeBusy = -32004
+	// ErrCodeRateLimited means that user calls the method too often.
+	ErrCodeRateLimited = -32005
+
+	// ErrCodeTimeout means that request was not handled in time.
+	ErrCodeTimeout = -32001
+)
@@ -0,0 +1,40 @@
+package chat
+
+// SetFeatures sets features supported by user client, e.g. event types it
+// could render. Clients declare them in "features" param of "hello" request.
+func (u *User) SetFeatures(features ...string) {
+	fs := make(map[string]bool, len(features))
+	for _, f := range features {
+		fs[f] = true
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.features = fs
+}
+
+// Supports reports whether user client declared support of feature.
+func (u *User) Supports(feature string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.features[feature]
+}
+
+// BroadcastFeature is like Broadcast but sends message only to users whose
+// clients declared support of feature, so new event types do not reach old
+// clients.
+func (c *Chat) BroadcastFeature(feature, method string, params Object) error {
+	return c.BroadcastWhere(func(u *User) bool {
+		return u.Supports(feature)
+	}, method, params)
+}
+
+// features returns strings listed in features param.
+func features(params Object) ([]string, bool) {
+	if _, has := params["features"]; !has {
+		return nil, true
+	}
+	return params.GetStringSlice("features")
+}
@@ -0,0 +1,86 @@
+package chat
+
+import "encoding/json"
+
+// FieldNamer returns name used on the wire for given field of messages,
+// that is "id", "method", "params", "result", "error", and also "v" and
+// "data" of Envelope.
+type FieldNamer func(field string) string
+
+var fields = [...]string{"id", "method", "params", "result", "error", "v", "data"}
+
+// fieldNames maps field names to wire names and back.
+type fieldNames struct {
+	out map[string]string
+	in  map[string]string
+}
+
+func newFieldNames(namer FieldNamer) *fieldNames {
+	names := &fieldNames{
+		out: make(map[string]string, len(fields)),
+		in:  make(map[string]string, len(fields)),
+	}
+	for _, f := range fields {
+		name := namer(f)
+		names.out[f] = name
+		names.in[name] = f
+	}
+	return names
+}
+
+// rename returns x marshaled with top-level field names replaced by names
+// from given map.
+func rename(x interface{}, names map[string]string) (map[string]json.RawMessage, error) {
+	bts, err := json.Marshal(x)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(bts, &obj); err != nil {
+		return nil, err
+	}
+	ret := make(map[string]json.RawMessage, len(obj))
+	for k, v := range obj {
+		if name, has := names[k]; has {
+			k = name
+		}
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+// encode prepares x to be sent with given protocol version and configured
+// field names.
+func (c *Chat) encode(version int, x interface{}) (interface{}, error) {
+	if c.names == nil {
+		return wrap(version, x), nil
+	}
+	inner, err := rename(x, c.names.out)
+	if err != nil {
+		return nil, err
+	}
+	if version == ProtocolV1 {
+		return inner, nil
+	}
+	return rename(wrap(version, inner), c.names.out)
+}
+
+// decode decodes request with configured field names.
+func (c *Chat) decode(p []byte, req *Request) error {
+	if c.names == nil {
+		return json.Unmarshal(p, req)
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(p, &obj); err != nil {
+		return err
+	}
+	renamed, err := rename(obj, c.names.in)
+	if err != nil {
+		return err
+	}
+	bts, err := json.Marshal(renamed)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bts, req)
+}
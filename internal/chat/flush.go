@@ -0,0 +1,77 @@
+package chat
+
+import (
+	"context"
+	"sync"
+)
+
+// inflight counts broadcasts and writes which are not done yet.
+type inflight struct {
+	mu      sync.Mutex
+	n       int
+	waiters []chan struct{}
+}
+
+func (f *inflight) add(delta int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.n += delta
+	if f.n > 0 {
+		return
+	}
+	for _, ch := range f.waiters {
+		close(ch)
+	}
+	f.waiters = nil
+}
+
+func (f *inflight) done() {
+	f.add(-1)
+}
+
+// pending returns number of writes which are not done yet.
+func (f *inflight) pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.n
+}
+
+// wait returns channel which is closed when counter drops to zero.
+func (f *inflight) wait() <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan struct{})
+	if f.n <= 0 {
+		close(ch)
+	} else {
+		f.waiters = append(f.waiters, ch)
+	}
+	return ch
+}
+
+// Flush blocks until there are no pending broadcasts and all scheduled writes
+// to users are done, or until ctx is done. Note that broadcasts started
+// during the call are waited too.
+func (c *Chat) Flush(ctx context.Context) error {
+	select {
+	case <-c.flight.wait():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain waits until broadcasts scheduled for user are written or ctx is done.
+// Broadcasts buffered while user was paused are written too.
+func (u *User) drain(ctx context.Context) {
+	if u.paused() {
+		u.Resume()
+	}
+	select {
+	case <-u.flight.wait():
+	case <-ctx.Done():
+	}
+}
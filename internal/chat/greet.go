@@ -0,0 +1,55 @@
+package chat
+
+// GreetPolicy defines when "greet" broadcast announcing new user is sent.
+type GreetPolicy int
+
+const (
+	// GreetAlways greets every registered user.
+	GreetAlways GreetPolicy = iota
+	// GreetReconnected defers greeting until Chat.SetIdentity() is called.
+	// Users which leave before that are not seen off by "goodbye" either.
+	// Identity which reconnects within ack window (see WithAckWindow()) is
+	// announced by quieter "reconnected" broadcast instead.
+	GreetReconnected
+	// GreetSilent is like GreetReconnected but nothing is sent for
+	// identity which reconnects within ack window.
+	GreetSilent
+)
+
+// greet broadcasts that user joined.
+func (c *Chat) greet(user *User) error {
+	if c.roster.window > 0 {
+		c.rosterChange(user.name, true)
+		return nil
+	}
+	return c.broadcastRoster("greet", withAvatar(user, Object{
+		"name": user.name,
+		"time": timestamp(),
+	}))
+}
+
+// greetIdentity greets user which just set identity if greeting is deferred
+// by policy. Resumed is true when identity reconnected within ack window.
+func (c *Chat) greetIdentity(user *User, resumed bool) error {
+	if c.greetPolicy == GreetAlways {
+		return nil
+	}
+	c.mu.Lock()
+	greeted := user.greeted
+	user.greeted = true
+	name := user.name
+	c.mu.Unlock()
+
+	switch {
+	case greeted:
+		return nil
+	case !resumed:
+		return c.greet(user)
+	case c.greetPolicy == GreetReconnected:
+		return c.broadcastRoster("reconnected", Object{
+			"name": name,
+			"time": timestamp(),
+		})
+	}
+	return nil
+}
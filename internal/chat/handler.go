@@ -0,0 +1,128 @@
+package chat
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+)
+
+// HandlerFunc handles request with registered method. Ctx carries trace
+// context started by Tracer (see WithTracer()). Returned result is written as
+// response. If error is returned, error response is written instead; error
+// code is set if err is CodeError.
+type HandlerFunc func(ctx context.Context, u *User, req *Request) (Object, error)
+
+// MethodInfo describes registered method for clients, e.g. help UIs.
+type MethodInfo struct {
+	Description string `json:"description,omitempty"`
+	// Params is a schema of method params, e.g. JSON Schema object.
+	Params Object `json:"params,omitempty"`
+}
+
+// CodeError is an error with code sent in error response.
+type CodeError struct {
+	Code    int
+	Message string
+}
+
+func (e CodeError) Error() string {
+	return e.Message
+}
+
+type handler struct {
+	fn   HandlerFunc
+	info MethodInfo
+}
+
+// Handle registers handler of method with given name. Registered methods are
+// listed by built-in "methods" method. Built-in methods could not be
+// overridden.
+func (c *Chat) Handle(method string, fn HandlerFunc, info MethodInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.handlers[method] = handler{fn, info}
+}
+
+// HandleDefault registers handler of methods which are neither built-in nor
+// registered by Handle(), e.g. to proxy them to a backend. Without it such
+// methods get "not implemented" error.
+func (c *Chat) HandleDefault(fn HandlerFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.fallback = fn
+}
+
+// Methods returns registered methods with their descriptions.
+func (c *Chat) Methods() []Object {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := make([]string, 0, len(c.handlers))
+	for name := range c.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ret := make([]Object, len(names))
+	for i, name := range names {
+		h := c.handlers[name]
+		ret[i] = Object{
+			"method":      name,
+			"description": h.info.Description,
+			"params":      h.info.Params,
+		}
+	}
+	return ret
+}
+
+func (c *Chat) handler(method string) (HandlerFunc, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	h, has := c.handlers[method]
+	if !has && c.fallback != nil {
+		return c.fallback, true
+	}
+	return h.fn, has
+}
+
+// handle calls registered handler of req method.
+func (u *User) handle(ctx context.Context, req *Request) error {
+	fn, has := u.chat.handler(req.Method)
+	if !has {
+		return u.writeErrorTo(req, Object{
+			"error": "not implemented",
+		})
+	}
+	// Receive could be called concurrently for the same user, e.g. when
+	// requests are dispatched to the pool, so limit concurrent handlers.
+	if n := u.chat.maxInFlight; n > 0 {
+		defer atomic.AddInt32(&u.handling, -1)
+		if atomic.AddInt32(&u.handling, 1) > int32(n) {
+			return u.writeErrorTo(req, Object{
+				"error": "too many requests in flight",
+				"code":  ErrCodeBusy,
+			})
+		}
+	}
+	return u.writeQueryResultTo(ctx, req, func() (Object, error) {
+		return fn(ctx, u, req)
+	})
+}
+
+// writeHandlerResultTo writes result as response to req, or err as error
+// response with its code if err is CodeError.
+func (u *User) writeHandlerResultTo(req *Request, result Object, err error) error {
+	if err == nil {
+		return u.writeResultTo(req, result)
+	}
+	obj := Object{
+		"error": err.Error(),
+	}
+	if ce, ok := err.(CodeError); ok {
+		obj["code"] = ce.Code
+	}
+	return u.writeErrorTo(req, obj)
+}
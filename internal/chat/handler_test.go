@@ -0,0 +1,86 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/gobwas/ws/wsutil"
+)
+
+func TestMaxInFlight(t *testing.T) {
+	const n = 2
+	var (
+		entered = make(chan struct{})
+		release = make(chan struct{})
+	)
+	c := NewChat(nil, WithMaxInFlight(n))
+	c.Handle("slow", func(context.Context, *User, *Request) (Object, error) {
+		entered <- struct{}{}
+		<-release
+		return nil, nil
+	}, MethodInfo{})
+	conn := &recordConn{}
+	u := &User{chat: c, conn: conn}
+
+	req := func(id int) *Request {
+		return &Request{
+			ID:     json.RawMessage(strconv.Itoa(id)),
+			Method: "slow",
+		}
+	}
+	var wg sync.WaitGroup
+	for i := 1; i <= n; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			u.handle(context.Background(), req(id))
+		}(i)
+		<-entered
+	}
+	// Handler is not entered over the limit, so it does not block.
+	if err := u.handle(context.Background(), req(n+1)); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	wg.Wait()
+
+	busy := map[string]bool{}
+	for conn.Len() > 0 {
+		p, _, err := wsutil.ReadServerData(&conn.Buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp struct {
+			ID    json.RawMessage
+			Error Object
+		}
+		if err := json.Unmarshal(p, &resp); err != nil {
+			t.Fatal(err)
+		}
+		code, _ := resp.Error["code"].(float64)
+		busy[string(resp.ID)] = int(code) == ErrCodeBusy
+	}
+	for i := 1; i <= n+1; i++ {
+		want := i > n
+		if got := busy[strconv.Itoa(i)]; got != want {
+			t.Errorf("request %d busy is %t; want %t", i, got, want)
+		}
+	}
+	if len(busy) != n+1 {
+		t.Errorf("got %d responses; want %d", len(busy), n+1)
+	}
+
+	// Slots are freed when handlers return.
+	go func() { <-entered }()
+	if err := u.handle(context.Background(), req(n+2)); err != nil {
+		t.Fatal(err)
+	}
+	p, _, _ := wsutil.ReadServerData(&conn.Buffer)
+	var resp struct{ Error Object }
+	if json.Unmarshal(p, &resp); resp.Error != nil {
+		t.Errorf("request after release failed: %v", resp.Error)
+	}
+}
@@ -0,0 +1,339 @@
+package chat_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/suryatresna/multiplayerengine/internal/chat"
+	"github.com/suryatresna/multiplayerengine/internal/chat/chattest"
+)
+
+// callCode calls method and returns code of error response.
+func callCode(t *testing.T, cl *chattest.Client, method string, params chat.Object) int {
+	t.Helper()
+
+	_, err := cl.Call(callContext(t), method, params)
+	if err == nil {
+		return 0
+	}
+	ce, ok := err.(*chattest.CallError)
+	if !ok {
+		t.Fatalf("%s error: %v", method, err)
+	}
+	return ce.Code
+}
+
+func TestNilParams(t *testing.T) {
+	c := chat.NewChat(nil)
+	p := connect(t, c)
+
+	if err := p.cl.Notify("publish", nil); err != nil {
+		t.Fatal(err)
+	}
+	pub := expect(t, p.cl, "publish")
+	if name := c.UserInfo(p.u)["name"]; pub.Params["author"] != name {
+		t.Errorf("publish author is %v; want %v", pub.Params["author"], name)
+	}
+	if code := callCode(t, p.cl, "rename", nil); code != chat.ErrCodeInvalidParams {
+		t.Errorf("rename error code is %d; want %d", code, chat.ErrCodeInvalidParams)
+	}
+	// Connection survives both.
+	if _, err := p.cl.Call(callContext(t), "count", nil); err != nil {
+		t.Errorf("count error: %v", err)
+	}
+}
+
+func TestMaxTextLen(t *testing.T) {
+	const n = 4
+	c := chat.NewChat(nil, chat.WithMaxTextLen(n))
+	p := connect(t, c)
+
+	// Multibyte runes count once: 4 runes, 10 bytes.
+	text := "héj😀"
+	if err := p.cl.Notify("publish", chat.Object{"text": text}); err != nil {
+		t.Fatal(err)
+	}
+	if pub := expect(t, p.cl, "publish"); pub.Params["text"] != text {
+		t.Errorf("published text is %v; want %q", pub.Params["text"], text)
+	}
+	for _, key := range []string{"text", "body"} {
+		code := callCode(t, p.cl, "publish", chat.Object{key: text + "é"})
+		if code != chat.ErrCodeInvalidParams {
+			t.Errorf("publish %s of %d runes: error code is %d; want %d", key, n+1, code, chat.ErrCodeInvalidParams)
+		}
+	}
+}
+
+func TestRenameCooldown(t *testing.T) {
+	const d = 100 * time.Millisecond
+	c := chat.NewChat(nil, chat.WithRenameCooldown(d))
+	p := connect(t, c)
+
+	if code := callCode(t, p.cl, "rename", chat.Object{"name": "a"}); code != 0 {
+		t.Fatalf("first rename error code is %d", code)
+	}
+	if code := callCode(t, p.cl, "rename", chat.Object{"name": "b"}); code != chat.ErrCodeRateLimited {
+		t.Errorf("rename during cooldown: error code is %d; want %d", code, chat.ErrCodeRateLimited)
+	}
+	if name := c.UserInfo(p.u)["name"]; name != "a" {
+		t.Errorf("name is %v after rejected rename; want a", name)
+	}
+	time.Sleep(d)
+	if code := callCode(t, p.cl, "rename", chat.Object{"name": "c"}); code != 0 {
+		t.Errorf("rename after cooldown: error code is %d", code)
+	}
+}
+
+func TestAnonymousTyping(t *testing.T) {
+	c := chat.NewChat(nil, chat.WithAnonymous("someone"))
+	p := connect(t, c)
+
+	if err := p.cl.Notify("typing", nil); err != nil {
+		t.Fatal(err)
+	}
+	typing := expect(t, p.cl, "typing")
+	names, _ := typing.Params["typing"].([]interface{})
+	if len(names) != 1 || names[0] != "someone" {
+		t.Errorf("typing names are %v; want [someone]", typing.Params["typing"])
+	}
+}
+
+func TestGreetPolicyGoodbye(t *testing.T) {
+	c := chat.NewChat(nil, chat.WithGreetPolicy(chat.GreetReconnected))
+	a := connect(t, c)
+	b := connect(t, c)
+	d := connect(t, c)
+	if err := c.SetIdentity(d.u, "d"); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, a.cl, "greet")
+
+	// Only greeted d is seen off.
+	name := c.UserInfo(d.u)["name"]
+	c.Remove(b.u)
+	c.Remove(d.u)
+	flush(t, c)
+	// Response follows notices written before.
+	if _, err := a.cl.Call(callContext(t), "count", nil); err != nil {
+		t.Fatal(err)
+	}
+	var byes []interface{}
+	for len(a.cl.Subscribe()) > 0 {
+		if req := <-a.cl.Subscribe(); req.Method == "goodbye" {
+			byes = append(byes, req.Params["name"])
+		}
+	}
+	if len(byes) != 1 || byes[0] != name {
+		t.Errorf("goodbye of %v; want only greeted %v", byes, name)
+	}
+}
+
+func TestTracerContext(t *testing.T) {
+	type key struct{}
+	var (
+		mu      sync.Mutex
+		parents = map[string]interface{}{}
+	)
+	tracer := chat.TracerFunc(func(parent context.Context, method string, req *chat.Request) (context.Context, func()) {
+		mu.Lock()
+		parents[method] = parent.Value(key{})
+		mu.Unlock()
+		return context.WithValue(parent, key{}, method), nil
+	})
+	c := chat.NewChat(nil, chat.WithTracer(tracer))
+	c.Handle("echo", func(ctx context.Context, u *chat.User, req *chat.Request) (chat.Object, error) {
+		return chat.Object{"span": ctx.Value(key{})}, nil
+	}, chat.MethodInfo{})
+	p := connect(t, c)
+
+	resp, err := p.cl.Call(callContext(t), "echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Result["span"] != "echo" {
+		t.Errorf("handler context span is %v; want echo", resp.Result["span"])
+	}
+	if err := p.cl.Notify("publish", nil); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, p.cl, "publish")
+
+	mu.Lock()
+	defer mu.Unlock()
+	// Request is traced as "publish" too, so its broadcast is the child.
+	if parents["publish"] != "publish" {
+		t.Errorf("publish broadcast parent span is %v; want publish request", parents["publish"])
+	}
+}
+
+func TestSeqParams(t *testing.T) {
+	c := chat.NewChat(nil)
+	p := connect(t, c)
+
+	for _, test := range []struct {
+		method string
+		params chat.Object
+	}{
+		{"ack", chat.Object{"seq": 1.5}},
+		{"ack", chat.Object{"seq": 1e300}},
+		{"ack", chat.Object{"seq": "1"}},
+		{"replay_from", chat.Object{"seq": 0.5}},
+		{"replay_from", chat.Object{"seq": -1}},
+		{"nack", chat.Object{"missing": []interface{}{1, 2.5}}},
+		{"hello", chat.Object{"version": 1.5}},
+	} {
+		if code := callCode(t, p.cl, test.method, test.params); code != chat.ErrCodeInvalidParams {
+			t.Errorf("%s %v: error code is %d; want %d", test.method, test.params, code, chat.ErrCodeInvalidParams)
+		}
+	}
+	if _, err := p.cl.Call(callContext(t), "replay_from", chat.Object{"seq": 0}); err != nil {
+		t.Errorf("replay_from 0 error: %v", err)
+	}
+}
+
+func TestReauthNilVerify(t *testing.T) {
+	c := chat.NewChat(nil, chat.WithReauth(time.Hour, time.Hour, nil))
+	p := connect(t, c)
+
+	if _, err := p.cl.Call(callContext(t), "reauth", chat.Object{"token": "x"}); err == nil {
+		t.Fatal("reauth without verify succeeded")
+	}
+	for range p.cl.Subscribe() {
+		// Wait for disconnect.
+	}
+	if r := p.u.CloseReason(); r != chat.CloseReauth {
+		t.Errorf("close reason is %v; want %v", r, chat.CloseReauth)
+	}
+}
+
+func TestReconnectBackoffZeroDelay(t *testing.T) {
+	c := chat.NewChat(nil, chat.WithReconnectBackoff(0, time.Minute, 0))
+	for i := 0; i < 2; i++ {
+		p := connect(t, c)
+		if hello := expect(t, p.cl, "hello"); hello.Params["retryAfter"] != nil {
+			t.Errorf("hello has retryAfter %v with zero delay", hello.Params["retryAfter"])
+		}
+	}
+}
+
+func TestRenameEmpty(t *testing.T) {
+	c := chat.NewChat(nil)
+	p := connect(t, c)
+	prev := c.UserInfo(p.u)["name"].(string)
+
+	for _, name := range []string{"", " \t"} {
+		if code := callCode(t, p.cl, "rename", chat.Object{"name": name}); code != chat.ErrCodeInvalidParams {
+			t.Errorf("rename to %q: error code is %d; want %d", name, code, chat.ErrCodeInvalidParams)
+		}
+	}
+	if _, err := p.cl.Call(callContext(t), "rename", chat.Object{"name": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	c.Remove(p.u)
+	for _, name := range []string{"", prev, "x"} {
+		if _, ok := c.Lookup(name); ok {
+			t.Errorf("Lookup(%q) found removed user", name)
+		}
+	}
+}
+
+func TestKickAllWaiting(t *testing.T) {
+	c := chat.NewChat(nil, chat.WithMaxUsers(1, 5))
+	a := connect(t, c)
+	b := connect(t, c)
+	if !b.u.Waiting() {
+		t.Fatal("second user is not waiting")
+	}
+
+	if err := c.KickAll("incident"); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []peer{a, b} {
+		waitClosed(t, p)
+		if r := p.u.CloseReason(); r != chat.CloseKicked {
+			t.Errorf("close reason is %v; want %v", r, chat.CloseKicked)
+		}
+	}
+	// Removal by read loops does not admit anyone either.
+	c.Remove(a.u)
+	c.Remove(b.u)
+	if n := c.Count(); n != 0 {
+		t.Errorf("Count() = %d after KickAll; want 0", n)
+	}
+}
+
+func TestSpectate(t *testing.T) {
+	var admin *chat.User
+	c := chat.NewChat(nil, chat.WithAdmin(func(u *chat.User) bool {
+		return u == admin
+	}))
+	a := connect(t, c)
+	b := connect(t, c)
+	admin = a.u
+	name := c.UserInfo(b.u)["name"].(string)
+	params := chat.Object{"name": name, "spectator": true}
+
+	if code := callCode(t, b.cl, "spectate", params); code != chat.ErrCodeForbidden {
+		t.Errorf("spectate by non-admin: error code is %d; want %d", code, chat.ErrCodeForbidden)
+	}
+	if _, err := a.cl.Call(callContext(t), "spectate", params); err != nil {
+		t.Fatal(err)
+	}
+	if !b.u.Spectator() {
+		t.Fatal("user is not spectator after spectate")
+	}
+	if code := callCode(t, b.cl, "typing", nil); code != chat.ErrCodeForbidden {
+		t.Errorf("typing by spectator: error code is %d; want %d", code, chat.ErrCodeForbidden)
+	}
+
+	params["spectator"] = false
+	if _, err := a.cl.Call(callContext(t), "spectate", params); err != nil {
+		t.Fatal(err)
+	}
+	if b.u.Spectator() {
+		t.Error("user is still spectator after promotion")
+	}
+	if code := callCode(t, a.cl, "spectate", chat.Object{"name": "nobody", "spectator": true}); code != chat.ErrCodeInvalidParams {
+		t.Errorf("spectate unknown user: error code is %d; want %d", code, chat.ErrCodeInvalidParams)
+	}
+}
+
+func TestRequestDeadline(t *testing.T) {
+	c := chat.NewChat(nil)
+	c.Handle("slow", func(ctx context.Context, u *chat.User, req *chat.Request) (chat.Object, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			return nil, chat.CodeError{Code: chat.ErrCodeInvalidParams, Message: "no deadline"}
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, chat.MethodInfo{})
+	p := connect(t, c)
+
+	if code := callCode(t, p.cl, "slow", chat.Object{"deadline": 20}); code != chat.ErrCodeTimeout {
+		t.Errorf("handler past deadline: error code is %d; want %d", code, chat.ErrCodeTimeout)
+	}
+	resp, err := p.cl.Call(callContext(t), "replay_from", chat.Object{"seq": 0, "deadline": 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp.Result["messages"]; !ok {
+		t.Errorf("replay_from with deadline result is %v; want messages", resp.Result)
+	}
+}
+
+func TestCallNoResponse(t *testing.T) {
+	c := chat.NewChat(nil)
+	p := connect(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.cl.Call(ctx, "ack", chat.Object{"seq": 0}); err != context.DeadlineExceeded {
+		t.Errorf("Call(ack) error is %v; want %v", err, context.DeadlineExceeded)
+	}
+	// Client is still usable.
+	if _, err := p.cl.Call(callContext(t), "count", nil); err != nil {
+		t.Fatal(err)
+	}
+}
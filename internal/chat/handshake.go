@@ -0,0 +1,55 @@
+package chat
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gobwas/ws"
+)
+
+// ErrNoSubprotocol returned by Upgrade in strict mode when client does not
+// offer any of supported subprotocols.
+var ErrNoSubprotocol = fmt.Errorf("chat: no supported subprotocol")
+
+// Upgrade upgrades HTTP connection to the websocket one. It selects first
+// subprotocol requested by client which is supported by chat (see
+// WithSubprotocols()). Selected subprotocol is returned in handshake and
+// could be passed to RegisterHandshake(). Clients locked out after too many
+// authentication failures are rejected with 429 status and ErrLockedOut.
+func (c *Chat) Upgrade(r *http.Request, w http.ResponseWriter) (net.Conn, ws.Handshake, error) {
+	if c.rejectLocked(r, w) {
+		return nil, ws.Handshake{}, ErrLockedOut
+	}
+	if c.strictSubprotocol && !c.offersSubprotocol(r) {
+		http.Error(w, "unsupported subprotocol", http.StatusBadRequest)
+		return nil, ws.Handshake{}, ErrNoSubprotocol
+	}
+	u := ws.HTTPUpgrader{
+		Protocol: c.supportsSubprotocol,
+	}
+	conn, _, hs, err := u.Upgrade(r, w)
+
+	return conn, hs, err
+}
+
+func (c *Chat) offersSubprotocol(r *http.Request) bool {
+	for _, h := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(h, ",") {
+			if c.supportsSubprotocol(strings.TrimSpace(p)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *Chat) supportsSubprotocol(p string) bool {
+	for _, s := range c.subprotocols {
+		if s == p {
+			return true
+		}
+	}
+	return false
+}
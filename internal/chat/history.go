@@ -0,0 +1,78 @@
+package chat
+
+import "sort"
+
+// entry is a broadcast retained in history.
+type entry struct {
+	seq uint64
+	req Request
+
+	target
+}
+
+// history retains last broadcasts.
+// Guarded by chat broadcast mutex.
+type history struct {
+	size    int
+	entries []entry
+}
+
+func (h *history) append(e entry) {
+	if h.size <= 0 {
+		return
+	}
+	if len(h.entries) >= h.size {
+		h.entries = h.entries[len(h.entries)-h.size+1:]
+	}
+	h.entries = append(h.entries, e)
+}
+
+// Replay returns retained broadcasts with sequence number greater than seq
+// which were delivered to user. Gap is true if some of such broadcasts are
+// not retained anymore.
+func (c *Chat) Replay(u *User, seq uint64) (reqs []Request, gap bool) {
+	c.bmu.Lock()
+	last := c.bseq
+	entries := c.h.entries
+	c.bmu.Unlock()
+
+	if seq >= last {
+		return nil, false
+	}
+	if len(entries) == 0 || entries[0].seq > seq+1 {
+		gap = true
+	}
+	for _, e := range entries {
+		if e.seq <= seq {
+			continue
+		}
+		if e.deliversTo(u) {
+			reqs = append(reqs, e.personalized(e.req, u))
+		}
+	}
+	return reqs, gap
+}
+
+// Resend writes to user retained broadcasts with given sequence numbers.
+// It returns sequence numbers of broadcasts which are not retained anymore
+// or were not delivered to user.
+func (c *Chat) Resend(u *User, seqs []uint64) (unavailable []uint64, err error) {
+	c.bmu.Lock()
+	entries := c.h.entries
+	c.bmu.Unlock()
+
+	for _, seq := range seqs {
+		i := sort.Search(len(entries), func(i int) bool {
+			return entries[i].seq >= seq
+		})
+		if i == len(entries) || entries[i].seq != seq || !entries[i].deliversTo(u) {
+			unavailable = append(unavailable, seq)
+			continue
+		}
+		e := entries[i]
+		if err := u.write(e.personalized(e.req, u)); err != nil {
+			return unavailable, err
+		}
+	}
+	return unavailable, nil
+}
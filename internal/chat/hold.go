@@ -0,0 +1,63 @@
+package chat
+
+import "time"
+
+// hold reserves name of removed user for its identity.
+type hold struct {
+	identity string
+	expires  time.Time
+}
+
+// holdName reserves name of removed user with identity, so it could be
+// reclaimed on quick reconnect.
+// mutex must be held.
+func (c *Chat) holdName(user *User) {
+	if c.nameHold <= 0 || user.identity == "" {
+		return
+	}
+	c.hold(user.name, user.identity, c.nameHold)
+}
+
+// hold reserves name for identity during d.
+// mutex must be held.
+func (c *Chat) hold(name, identity string, d time.Duration) {
+	expires := time.Now().Add(d)
+	c.holds[name] = hold{identity, expires}
+	c.held[identity] = name
+	time.AfterFunc(d, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if c.holds[name].expires == expires {
+			c.release(name)
+		}
+	})
+}
+
+// reserved reports whether name is held for identity other than user's.
+// Hold is released when user has the same identity.
+// mutex must be held.
+func (c *Chat) reserved(user *User, name string) bool {
+	h, has := c.holds[name]
+	if !has {
+		return false
+	}
+	if h.identity == user.identity {
+		c.release(name)
+		return false
+	}
+	return true
+}
+
+// release releases held name.
+// mutex must be held.
+func (c *Chat) release(name string) {
+	h, has := c.holds[name]
+	if !has {
+		return
+	}
+	delete(c.holds, name)
+	if c.held[h.identity] == name {
+		delete(c.held, h.identity)
+	}
+}
@@ -0,0 +1,81 @@
+package chat
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// maxIdempotencyKeyLen is the maximum length of publish "key" param.
+const maxIdempotencyKeyLen = 64
+
+// idempotency is a small LRU of recent publish idempotency keys of user.
+type idempotency struct {
+	mu    sync.Mutex
+	keys  map[string]*list.Element
+	order list.List // Front is the most recent key.
+}
+
+type published struct {
+	key string
+	seq uint64
+	at  time.Time
+}
+
+// lookup returns sequence number of the broadcast published with key within
+// ttl. It must be called with mu held.
+func (d *idempotency) lookup(key string, ttl time.Duration) (uint64, bool) {
+	el, has := d.keys[key]
+	if !has {
+		return 0, false
+	}
+	p := el.Value.(published)
+	if time.Since(p.at) > ttl {
+		d.order.Remove(el)
+		delete(d.keys, key)
+		return 0, false
+	}
+	return p.seq, true
+}
+
+// store remembers key of broadcast with given sequence number, evicting the
+// least recent key if there are more than size keys. It must be called with
+// mu held.
+func (d *idempotency) store(key string, seq uint64, size int) {
+	if d.keys == nil {
+		d.keys = make(map[string]*list.Element)
+	}
+	d.keys[key] = d.order.PushFront(published{
+		key: key,
+		seq: seq,
+		at:  time.Now(),
+	})
+	for d.order.Len() > size {
+		el := d.order.Back()
+		d.order.Remove(el)
+		delete(d.keys, el.Value.(published).key)
+	}
+}
+
+// publishOnce broadcasts publish with idempotency key. If key was already
+// published within TTL, or publish is squelched, broadcast is not repeated
+// and sequence number of the original one is returned with dup set to true.
+func (u *User) publishOnce(ctx context.Context, key string, params Object) (seq uint64, dup bool, err error) {
+	c := u.chat
+	d := &u.idempotency
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if seq, has := d.lookup(key, c.idempotencyTTL); has {
+		return seq, true, nil
+	}
+	delete(params, "key")
+	seq, dup, err = u.publish(ctx, params)
+	if err != nil {
+		return 0, false, err
+	}
+	d.store(key, seq, c.idempotencySize)
+	return seq, dup, nil
+}
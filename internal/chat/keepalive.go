@@ -0,0 +1,25 @@
+package chat
+
+import (
+	"net"
+	"time"
+)
+
+// setKeepAlive enables TCP keep-alive with given period on conn. Wrapped
+// connections, e.g. *tls.Conn, are unwrapped to the underlying one. It does
+// nothing for connections which are not TCP.
+func setKeepAlive(conn net.Conn, period time.Duration) error {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			if err := c.SetKeepAlive(true); err != nil {
+				return err
+			}
+			return c.SetKeepAlivePeriod(period)
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return nil
+		}
+	}
+}
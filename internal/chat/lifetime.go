@@ -0,0 +1,53 @@
+package chat
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// ConnectedAt returns time when user was registered.
+func (u *User) ConnectedAt() time.Time {
+	return u.connectedAt
+}
+
+// startLifetime schedules disconnect of user after maximum connection
+// lifetime with random jitter, so long-lived connections do not drop at
+// the same time.
+func (u *User) startLifetime() {
+	c := u.chat
+	if c.maxLifetime <= 0 {
+		return
+	}
+	d := c.maxLifetime
+	if c.lifetimeJitter > 0 {
+		d += time.Duration(rand.Int63n(int64(c.lifetimeJitter)))
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.lifetime = time.AfterFunc(d, u.expire)
+}
+
+func (u *User) stopLifetime() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.lifetime != nil {
+		u.lifetime.Stop()
+	}
+}
+
+// expire gracefully disconnects user with reconnect hint.
+func (u *User) expire() {
+	ctx, cancel := context.WithTimeout(context.Background(), u.chat.drainTimeout)
+	defer cancel()
+
+	u.drain(ctx)
+	u.writeNotice("reconnect", Object{
+		"time": timestamp(),
+	})
+	u.closeFor(CloseLifetime, "reconnect")
+	u.chat.Remove(u)
+}
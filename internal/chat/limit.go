@@ -0,0 +1,128 @@
+package chat
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBusy returned by Broadcast when global broadcast rate is exceeded or
+// broadcast queue is full.
+var ErrBusy = fmt.Errorf("chat: too many broadcasts")
+
+// RatePolicy defines what happens with broadcasts over the global rate.
+type RatePolicy int
+
+const (
+	// RateShed drops broadcasts over the rate with ErrBusy.
+	RateShed RatePolicy = iota
+	// RateQueue delays broadcasts over the rate until there is a free token.
+	// Broadcasts are shed when queue is full.
+	RateQueue
+)
+
+// bucket is a token bucket limiting number of broadcasts per second.
+type bucket struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	waiting int
+
+	policy RatePolicy
+	queue  int
+}
+
+// take takes token from the bucket. It returns duration to wait before
+// token could be used, or false if broadcast must be shed.
+func (b *bucket) take() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	if b.policy != RateQueue || b.waiting >= b.queue {
+		return 0, false
+	}
+	// Reserve the token which is not there yet.
+	b.tokens--
+	b.waiting++
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second)), true
+}
+
+func (b *bucket) done() {
+	b.mu.Lock()
+	b.waiting--
+	b.mu.Unlock()
+}
+
+// limit waits for the global broadcast rate.
+func (c *Chat) limit() error {
+	if c.rate == nil {
+		return nil
+	}
+	wait, ok := c.rate.take()
+	if !ok {
+		atomic.AddUint64(&c.shed, 1)
+		return ErrBusy
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+		c.rate.done()
+	}
+	return nil
+}
+
+// enqueueBroadcast sends m to the writer holding semaphore of the queue,
+// which it returns. Caller must release semaphore once m is sequenced. If
+// queue timeout is set and m could not be sent during the timeout, including
+// the wait for semaphore, it returns false.
+func (c *Chat) enqueueBroadcast(out chan *message, m *message) (chan struct{}, bool) {
+	lock := c.outLock
+	if out == c.high {
+		lock = c.highLock
+	}
+	if out == c.high || c.queueTimeout == 0 {
+		lock <- struct{}{}
+		out <- m
+		return lock, true
+	}
+	select {
+	case lock <- struct{}{}:
+		select {
+		case out <- m:
+			return lock, true
+		default:
+			<-lock
+		}
+	default:
+	}
+	tm := time.NewTimer(c.queueTimeout)
+	defer tm.Stop()
+	select {
+	case lock <- struct{}{}:
+	case <-tm.C:
+		atomic.AddUint64(&c.shed, 1)
+		return nil, false
+	}
+	select {
+	case out <- m:
+		return lock, true
+	case <-tm.C:
+		<-lock
+		atomic.AddUint64(&c.shed, 1)
+		return nil, false
+	}
+}
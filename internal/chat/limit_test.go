@@ -0,0 +1,163 @@
+package chat_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/suryatresna/multiplayerengine/internal/chat"
+	"github.com/suryatresna/multiplayerengine/internal/gopool"
+)
+
+// discardConn discards everything written and has nothing to read.
+type discardConn struct{}
+
+func (discardConn) Read([]byte) (int, error)    { return 0, io.EOF }
+func (discardConn) Write(p []byte) (int, error) { return len(p), nil }
+func (discardConn) Close() error                { return nil }
+
+// pool adapts gopool.Pool to chat.GopoolInterface.
+type pool struct {
+	*gopool.Pool
+}
+
+func (pool) Add(net.Conn) error        { return nil }
+func (pool) Remove(net.Conn) error     { return nil }
+func (pool) Wait() ([]net.Conn, error) { return nil, nil }
+
+func newPool() pool {
+	return pool{gopool.NewPool(runtime.GOMAXPROCS(0)*4, 1024, 1)}
+}
+
+func TestGlobalRateRoster(t *testing.T) {
+	c := chat.NewChat(nil, chat.WithGlobalRate(0.001, 1, chat.RateShed, 0))
+	if err := c.Broadcast("x", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Broadcast("y", nil); err != chat.ErrBusy {
+		t.Fatalf("Broadcast() over the rate error is %v; want ErrBusy", err)
+	}
+
+	a := connect(t, c)
+	b := connect(t, c)
+	expect(t, a.cl, "greet")
+	if _, err := b.cl.Call(callContext(t), "rename", chat.Object{"name": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, a.cl, "rename")
+	b.cl.Close()
+	expect(t, a.cl, "goodbye")
+
+	if code := callCode(t, a.cl, "typing", nil); code != chat.ErrCodeBusy {
+		t.Errorf("typing over the rate: error code is %d; want %d", code, chat.ErrCodeBusy)
+	}
+}
+
+func TestBroadcastQueueTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	c := chat.NewChat(nil,
+		chat.WithOrdering(chat.OrderStrictGlobal),
+		chat.WithBroadcastQueue(1, timeout),
+	)
+	p := connect(t, c)
+	flush(t, c)
+
+	// Writer blocks writing "a" and "b" fills the queue.
+	blocked, release := p.conn.stall()
+	defer release()
+	c.Broadcast("a", nil)
+	<-blocked
+	c.Broadcast("b", nil)
+
+	// Each caller waits for its own timeout, not behind others.
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Broadcast("c", nil); err != chat.ErrBusy {
+				t.Errorf("Broadcast() error is %v; want ErrBusy", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if d := time.Since(start); d > 3*timeout {
+		t.Errorf("shedding took %v; want about %v", d, timeout)
+	}
+}
+
+func TestHealthInFlight(t *testing.T) {
+	c := chat.NewChat(nil, chat.WithOrdering(chat.OrderPerUser))
+	p := connect(t, c)
+	flush(t, c)
+
+	blocked, release := p.conn.stall()
+	defer release()
+	c.Broadcast("a", nil)
+	<-blocked
+	if n, _ := c.Health().GetInt("inFlight"); n < 1 {
+		t.Errorf("inFlight is %d with stalled write; want at least 1", n)
+	}
+	release()
+	flush(t, c)
+	if n, _ := c.Health().GetInt("inFlight"); n != 0 {
+		t.Errorf("inFlight is %d after flush; want 0", n)
+	}
+}
+
+func TestBroadcastQueueNegative(t *testing.T) {
+	c := chat.NewChat(nil, chat.WithBroadcastQueue(-1, 0))
+	defer c.Shutdown(context.Background())
+	if err := c.Broadcast("x", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// BenchmarkJoinBurst registers 1000 users concurrently and reports latency
+// of registrations, each of which broadcasts "greet".
+func BenchmarkJoinBurst(b *testing.B) {
+	const burst = 1000
+	for _, bc := range []struct {
+		name string
+		opts []chat.Option
+	}{
+		{"queue1", []chat.Option{chat.WithBroadcastQueue(1, 0)}},
+		{"default", nil},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var lat []time.Duration
+			for i := 0; i < b.N; i++ {
+				c := chat.NewChat(newPool(), bc.opts...)
+				ds := make([]time.Duration, burst)
+				var wg sync.WaitGroup
+				start := make(chan struct{})
+				for j := range ds {
+					wg.Add(1)
+					go func(j int) {
+						defer wg.Done()
+						<-start
+						t := time.Now()
+						if _, err := c.RegisterConn(discardConn{}); err != nil {
+							b.Error(err)
+						}
+						ds[j] = time.Since(t)
+					}(j)
+				}
+				close(start)
+				wg.Wait()
+				flush(b, c)
+				c.Shutdown(context.Background())
+				lat = append(lat, ds...)
+			}
+			sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+			b.ReportMetric(float64(lat[len(lat)/2].Microseconds()), "p50-µs")
+			b.ReportMetric(float64(lat[len(lat)*99/100].Microseconds()), "p99-µs")
+		})
+	}
+}
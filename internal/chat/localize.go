@@ -0,0 +1,53 @@
+package chat
+
+// BroadcastLocalized sends message with given key to all alive users. Each
+// user receives params with "key" and "text" set to the result of localize
+// func set by WithLocalize(). Unlike Broadcast() message is framed for every
+// recipient, so it should be used for system messages only.
+func (c *Chat) BroadcastLocalized(method, key string, data Object) error {
+	params := make(Object, len(data)+1)
+	for k, v := range data {
+		params[k] = v
+	}
+	params["key"] = key
+	params["text"] = key
+
+	t := target{}
+	if c.localize != nil {
+		t.personalize = func(u *User, params Object) {
+			params["text"] = c.localize(u, key, data)
+		}
+	}
+	return c.broadcast(c.out, t, method, params)
+}
+
+// personalized returns copy of r personalized for user u.
+func (t target) personalized(r Request, u *User) Request {
+	if t.personalize == nil {
+		return r
+	}
+	params := make(Object, len(r.Params))
+	for k, v := range r.Params {
+		params[k] = v
+	}
+	t.personalize(u, params)
+	r.Params = params
+
+	return r
+}
+
+// frameFor returns message framed for user u.
+func (c *Chat) frameFor(m message, u *User) []byte {
+	if m.personalize == nil {
+		return m.frame(u.Protocol())
+	}
+	x, err := c.encode(u.Protocol(), m.personalized(m.req, u))
+	if err != nil {
+		return nil
+	}
+	bts, err := frame(x)
+	if err != nil {
+		return nil
+	}
+	return bts
+}
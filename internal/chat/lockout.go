@@ -0,0 +1,148 @@
+package chat
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrLockedOut returned by Upgrade when client address is locked out after
+// too many authentication failures (see WithAuthLockout()).
+var ErrLockedOut = fmt.Errorf("chat: too many authentication failures")
+
+// lockout counts authentication failures per client address.
+type lockout struct {
+	mu        sync.Mutex
+	threshold int
+	base      time.Duration
+	max       time.Duration
+	addrs     map[string]*failures
+	swept     time.Time
+}
+
+type failures struct {
+	n     int
+	last  time.Time
+	until time.Time
+}
+
+func newLockout(threshold int, base, max time.Duration) *lockout {
+	return &lockout{
+		threshold: threshold,
+		base:      base,
+		max:       max,
+		addrs:     make(map[string]*failures),
+	}
+}
+
+// fail counts failure from addr. It returns true if addr is locked out now.
+func (l *lockout) fail(addr string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	f := l.addrs[addr]
+	if f == nil {
+		f = new(failures)
+		l.addrs[addr] = f
+	}
+	f.n++
+	f.last = now
+	if f.n < l.threshold {
+		return false
+	}
+	// Lockout doubles with every failure over the threshold.
+	d := l.max
+	if shift := uint(f.n - l.threshold); shift < 32 {
+		if x := l.base << shift; x > 0 && x < d {
+			d = x
+		}
+	}
+	f.until = now.Add(d)
+	return true
+}
+
+// reset forgets failures of addr.
+func (l *lockout) reset(addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.addrs, addr)
+}
+
+// locked returns time left until lockout of addr ends.
+func (l *lockout) locked(addr string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f := l.addrs[addr]; f != nil && now.Before(f.until) {
+		return f.until.Sub(now)
+	}
+	return 0
+}
+
+// sweep forgets addresses which did not fail for max lockout duration.
+// mutex must be held.
+func (l *lockout) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.max {
+		return
+	}
+	l.swept = now
+	for addr, f := range l.addrs {
+		if now.Sub(f.last) >= l.max && !now.Before(f.until) {
+			delete(l.addrs, addr)
+		}
+	}
+}
+
+// AuthFailed counts authentication failure from client address addr, e.g.
+// when application rejects token passed on upgrade. It returns true if addr
+// is locked out now. It does nothing unless WithAuthLockout() is used.
+func (c *Chat) AuthFailed(addr string) bool {
+	if c.lockout == nil || addr == "" {
+		return false
+	}
+	return c.lockout.fail(hostOf(addr), time.Now())
+}
+
+// AuthSucceeded forgets authentication failures of client address addr.
+func (c *Chat) AuthSucceeded(addr string) {
+	if c.lockout != nil && addr != "" {
+		c.lockout.reset(hostOf(addr))
+	}
+}
+
+// rejectLocked responds with 429 status if client of r is locked out.
+func (c *Chat) rejectLocked(r *http.Request, w http.ResponseWriter) bool {
+	if c.lockout == nil {
+		return false
+	}
+	d := c.lockout.locked(hostOf(r.RemoteAddr), time.Now())
+	if d <= 0 {
+		return false
+	}
+	secs := int64((d + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	http.Error(w, "too many authentication failures", http.StatusTooManyRequests)
+	return true
+}
+
+// remoteAddr returns remote address of user connection, or empty string if
+// connection is not net.Conn.
+func (u *User) remoteAddr() string {
+	if conn, ok := u.conn.(net.Conn); ok && conn.RemoteAddr() != nil {
+		return conn.RemoteAddr().String()
+	}
+	return ""
+}
+
+// hostOf strips port from addr.
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,38 @@
+package chat
+
+// maxMentions is the maximum number of users mentioned in single publish.
+const maxMentions = 20
+
+// mentions returns names listed in "mentions" param of publish which belong
+// to connected users. It returns false if param is malformed.
+func (c *Chat) mentions(params Object) ([]string, bool) {
+	if _, has := params["mentions"]; !has {
+		return nil, true
+	}
+	list, ok := params.GetStringSlice("mentions")
+	if !ok || len(list) > maxMentions {
+		return nil, false
+	}
+	resolved := make([]string, 0, len(list))
+	for _, name := range list {
+		if _, has := c.Lookup(name); has && !hasWord(resolved, name) {
+			resolved = append(resolved, name)
+		}
+	}
+	return resolved, true
+}
+
+// notifyMentions sends "mention" notice about published broadcast with
+// given sequence number to mentioned users.
+func (u *User) notifyMentions(seq uint64, params Object, names []string) {
+	for _, name := range names {
+		if name == u.name {
+			continue
+		}
+		u.chat.sendTo(u, name, "mention", Object{
+			"seq":    seq,
+			"author": params["author"],
+			"time":   params["time"],
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package chat
+
+import "sync/atomic"
+
+// metrics contains chat counters. Accessed atomically.
+type metrics struct {
+	broadcasts  uint64
+	bytesIn     uint64
+	bytesOut    uint64
+	disconnects [numCloseReasons]uint64
+}
+
+// Collect returns current values of chat metrics to be exported to
+// monitoring system, e.g. as Prometheus gauges and counters. Keys are
+// metric names in Prometheus format with labels:
+//
+//	wsroom_connected
+//	wsroom_broadcasts_total
+//	wsroom_dropped_writes_total
+//	wsroom_shed_broadcasts_total
+//	wsroom_bytes_in_total
+//	wsroom_bytes_out_total
+//	wsroom_disconnects_total{reason="..."}
+//
+// Disconnect reasons are names of CloseReason values. Connections rejected
+// while draining are counted with "draining" reason.
+func (c *Chat) Collect() map[string]float64 {
+	m := map[string]float64{
+		"wsroom_connected":             float64(atomic.LoadInt64(&c.connected)),
+		"wsroom_broadcasts_total":      float64(atomic.LoadUint64(&c.metrics.broadcasts)),
+		"wsroom_dropped_writes_total":  float64(atomic.LoadUint64(&c.dropped)),
+		"wsroom_shed_broadcasts_total": float64(atomic.LoadUint64(&c.shed)),
+		"wsroom_bytes_in_total":        float64(atomic.LoadUint64(&c.metrics.bytesIn)),
+		"wsroom_bytes_out_total":       float64(atomic.LoadUint64(&c.metrics.bytesOut)),
+	}
+	for r := CloseReason(0); r < numCloseReasons; r++ {
+		key := `wsroom_disconnects_total{reason="` + r.String() + `"}`
+		m[key] = float64(atomic.LoadUint64(&c.metrics.disconnects[r]))
+	}
+	return m
+}
+
+// disconnects returns number of disconnects by reason name.
+func (c *Chat) disconnects() map[string]uint64 {
+	m := make(map[string]uint64, numCloseReasons)
+	for r := CloseReason(0); r < numCloseReasons; r++ {
+		m[r.String()] = atomic.LoadUint64(&c.metrics.disconnects[r])
+	}
+	return m
+}
+
+// countDisconnect counts disconnect of removed user.
+func (c *Chat) countDisconnect(u *User) {
+	atomic.AddUint64(&c.metrics.disconnects[u.CloseReason()], 1)
+}
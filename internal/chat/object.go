@@ -0,0 +1,104 @@
+package chat
+
+import "math"
+
+// GetString returns string value of key.
+func (o Object) GetString(key string) (string, bool) {
+	s, ok := o[key].(string)
+	return s, ok
+}
+
+// GetBool returns boolean value of key.
+func (o Object) GetBool(key string) (bool, bool) {
+	b, ok := o[key].(bool)
+	return b, ok
+}
+
+// GetFloat returns numeric value of key.
+func (o Object) GetFloat(key string) (float64, bool) {
+	switch v := o[key].(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// GetInt returns integer value of key. Decoded JSON numbers are float64, so
+// they are accepted if they have no fractional part and fit into int.
+func (o Object) GetInt(key string) (int, bool) {
+	return toInt(o[key])
+}
+
+// toInt converts v to int as GetInt does.
+func toInt(x interface{}) (int, bool) {
+	switch v := x.(type) {
+	case int:
+		return v, true
+	case int64:
+		if int64(int(v)) == v {
+			return int(v), true
+		}
+	case float64:
+		if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+			if n := int64(v); int64(int(n)) == n {
+				return int(n), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// GetStringSlice returns list of strings of key. It returns false if value
+// is not a list or any of its elements is not a string.
+func (o Object) GetStringSlice(key string) ([]string, bool) {
+	switch v := o[key].(type) {
+	case []string:
+		return v, true
+	case []interface{}:
+		ret := make([]string, len(v))
+		for i, x := range v {
+			s, ok := x.(string)
+			if !ok {
+				return nil, false
+			}
+			ret[i] = s
+		}
+		return ret, true
+	}
+	return nil, false
+}
+
+// GetIntSlice returns list of integers of key. It returns false if value is
+// not a list or any of its elements is not an integer as GetInt requires.
+func (o Object) GetIntSlice(key string) ([]int, bool) {
+	switch v := o[key].(type) {
+	case []int:
+		return v, true
+	case []interface{}:
+		ret := make([]int, len(v))
+		for i, x := range v {
+			n, ok := toInt(x)
+			if !ok {
+				return nil, false
+			}
+			ret[i] = n
+		}
+		return ret, true
+	}
+	return nil, false
+}
+
+// GetObject returns nested object of key.
+func (o Object) GetObject(key string) (Object, bool) {
+	switch v := o[key].(type) {
+	case Object:
+		return v, true
+	case map[string]interface{}:
+		return Object(v), true
+	}
+	return nil, false
+}
@@ -0,0 +1,541 @@
+package chat
+
+import "time"
+
+// Option configures Chat created by NewChat.
+type Option func(*Chat)
+
+// WithTracer sets tracer which is called on every received request and
+// broadcast.
+func WithTracer(t Tracer) Option {
+	return func(c *Chat) {
+		c.tracer = t
+	}
+}
+
+// WithWelcome sets func which returns additional params of the "hello" notice
+// sent to just registered user. User id and name are always set in the
+// notice and override "id" and "name" returned by fn.
+func WithWelcome(fn func(user *User) Object) Option {
+	return func(c *Chat) {
+		c.welcome = fn
+	}
+}
+
+// WithWelcomeParams is like WithWelcome but sends the same params to every
+// user.
+func WithWelcomeParams(params Object) Option {
+	return WithWelcome(func(*User) Object {
+		return params
+	})
+}
+
+// WithAvatars enables assignment of avatar tokens (emoji, colors and so on)
+// to registered users. Each user gets avatar from the pool which is not used
+// by others if possible.
+func WithAvatars(pool ...string) Option {
+	return func(c *Chat) {
+		c.avatars = append([]string(nil), pool...)
+	}
+}
+
+// WithReadTimeout sets maximum duration of reading single message from the
+// user connection, including its header. It is measured from the moment
+// when Receive is called, not between messages, so it protects from clients
+// which send messages very slowly. Receive must be called only when
+// connection is readable, e.g. with epoll, otherwise idle users are
+// disconnected too. Zero means no timeout.
+func WithReadTimeout(d time.Duration) Option {
+	return func(c *Chat) {
+		c.readTimeout = d
+	}
+}
+
+// WithSuffixFunc sets func which formats random name when generated animal
+// name is already taken, e.g. "bear-3" or "bear#7". Attempt starts from 1.
+// By default random digits are appended to the name, e.g. "bear37".
+func WithSuffixFunc(fn func(base string, attempt int) string) Option {
+	return func(c *Chat) {
+		c.suffix = fn
+	}
+}
+
+// WithSchedulePolicy sets behavior of broadcast writer when pool has no free
+// workers during given timeout. It has no effect for ScheduleBlock policy.
+func WithSchedulePolicy(p SchedulePolicy, timeout time.Duration) Option {
+	return func(c *Chat) {
+		c.policy = p
+		c.scheduleTimeout = timeout
+	}
+}
+
+// WithUserInfoFields sets fields returned by Chat.UserInfo() and "whoami" and
+// "list" methods. Known fields are "id", "name", "avatar" and "spectator".
+// All of them are returned by default.
+func WithUserInfoFields(fields ...string) Option {
+	return func(c *Chat) {
+		c.infoFields = append([]string(nil), fields...)
+	}
+}
+
+// WithCoalesce enables coalescing of broadcasts with given method sent by
+// Chat.BroadcastCoalesced(). Values are collected during window and then
+// broadcasted at once.
+func WithCoalesce(method string, window time.Duration) Option {
+	return func(c *Chat) {
+		c.co.windows[method] = window
+	}
+}
+
+// WithSubprotocols sets websocket subprotocols supported by Chat.Upgrade().
+// In strict mode connections which do not offer any of them are rejected.
+func WithSubprotocols(strict bool, protocols ...string) Option {
+	return func(c *Chat) {
+		c.subprotocols = append([]string(nil), protocols...)
+		c.strictSubprotocol = strict
+	}
+}
+
+// WithAttachments allows publishing of attachment metadata with given mime
+// types and maximum size in bytes. Attachments are uploaded elsewhere, chat
+// only routes {"url", "mime", "size"} object of "attachment" param. Publishes
+// with attachments are rejected unless this option is set.
+func WithAttachments(maxSize int64, mimes ...string) Option {
+	return func(c *Chat) {
+		c.maxAttachmentSize = maxSize
+		c.attachmentMimes = append([]string(nil), mimes...)
+	}
+}
+
+// WithReauth enables periodic re-authentication of users. Every interval
+// users receive "reauth" notice and must reply with "reauth" request with
+// fresh {"token"} param within timeout. Token is checked by verify; nil
+// verify rejects every token. Users which fail to re-authenticate are
+// disconnected.
+func WithReauth(interval, timeout time.Duration, verify func(u *User, token string) bool) Option {
+	return func(c *Chat) {
+		c.reauthInterval = interval
+		c.reauthTimeout = timeout
+		c.reauthVerify = verify
+	}
+}
+
+// WithNameFormat sets func which formats generated animal names, e.g.
+// TitleCase. Formatted name is the one which is shown to users and checked
+// for uniqueness.
+func WithNameFormat(fn func(string) string) Option {
+	return func(c *Chat) {
+		c.formatName = fn
+	}
+}
+
+// WithMaxTextLen sets maximum length in runes of "text" and "body" params of
+// published messages. Zero means no limit.
+func WithMaxTextLen(n int) Option {
+	return func(c *Chat) {
+		c.maxTextLen = n
+	}
+}
+
+// WithHistory sets number of last broadcasts retained for replay.
+func WithHistory(size int) Option {
+	return func(c *Chat) {
+		c.h.size = size
+	}
+}
+
+// WithPauseBuffer sets maximum number of broadcasts buffered for paused user.
+// Default is 100.
+func WithPauseBuffer(n int) Option {
+	return func(c *Chat) {
+		c.pauseBuffer = n
+	}
+}
+
+// WithIdleTimeout enables disconnecting of users which did not send any
+// message for longer than timeout. Users are checked by single background
+// sweeper every interval, and disconnects are randomly delayed within the
+// interval.
+func WithIdleTimeout(timeout, interval time.Duration) Option {
+	return func(c *Chat) {
+		c.idleTimeout = timeout
+		c.sweepInterval = interval
+	}
+}
+
+// WithFieldNamer sets names of message fields used on the wire, e.g. to
+// match naming conventions of the client. By default fields are named as in
+// Request, Response, Error and Envelope json tags.
+func WithFieldNamer(namer FieldNamer) Option {
+	return func(c *Chat) {
+		c.names = newFieldNames(namer)
+	}
+}
+
+// WithSessionPolicy sets policy applied when identity passed to
+// Chat.SetIdentity() is already connected. Default is SessionMulti.
+func WithSessionPolicy(p SessionPolicy) Option {
+	return func(c *Chat) {
+		c.session = p
+	}
+}
+
+// WithAckWindow sets duration during which acknowledged position of
+// disconnected identity is retained. When identity reconnects within the
+// window, broadcasts which were not acknowledged by "ack" requests are
+// replayed from history (see WithHistory()).
+func WithAckWindow(d time.Duration) Option {
+	return func(c *Chat) {
+		c.ackWindow = d
+	}
+}
+
+// WithTombstoneTTL sets duration during which name of removed user is
+// remembered, so Chat.SendTo() returns ErrRecentlyLeft for it.
+func WithTombstoneTTL(d time.Duration) Option {
+	return func(c *Chat) {
+		c.tombstoneTTL = d
+	}
+}
+
+// WithLocalize sets func which returns text of the system message with given
+// key translated for user, e.g. to the locale stored in user tag. It is used
+// by Chat.BroadcastLocalized().
+func WithLocalize(fn func(u *User, key string, data Object) string) Option {
+	return func(c *Chat) {
+		c.localize = fn
+	}
+}
+
+// WithDrainTimeout sets maximum duration of waiting for pending writes to
+// kicked users before their connections are closed. Default is one second.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(c *Chat) {
+		c.drainTimeout = d
+	}
+}
+
+// WithAnonymous enables anonymous mode, when published messages and typing
+// notices have given author instead of the sender name and avatar, and direct
+// messages are disabled.
+func WithAnonymous(author string) Option {
+	return func(c *Chat) {
+		c.anonymous = true
+		c.anonymousAuthor = author
+	}
+}
+
+// WithMaxNack sets maximum number of broadcasts which could be requested by
+// single "nack" request. Default is 100.
+func WithMaxNack(n int) Option {
+	return func(c *Chat) {
+		c.maxNack = n
+	}
+}
+
+// WithGlobalRate limits number of broadcasts per second across the whole
+// chat. Burst is the maximum number of broadcasts sent at once. Broadcasts
+// over the rate are handled according to policy; queue is the maximum number
+// of broadcasts waiting with RateQueue policy. Announcements and roster events,
+// e.g. "greet", "goodbye" and "rename", are not limited.
+func WithGlobalRate(rate float64, burst int, policy RatePolicy, queue int) Option {
+	return func(c *Chat) {
+		c.rate = &bucket{
+			rate:   rate,
+			burst:  float64(burst),
+			tokens: float64(burst),
+			last:   time.Now(),
+			policy: policy,
+			queue:  queue,
+		}
+	}
+}
+
+// WithKeepAlive enables TCP keep-alive with given period on registered
+// connections, so dead sockets are detected on the OS level.
+func WithKeepAlive(period time.Duration) Option {
+	return func(c *Chat) {
+		c.keepAlive = period
+	}
+}
+
+// WithOrdering sets ordering guarantees of broadcast delivery. Default is
+// OrderBestEffort.
+func WithOrdering(o Ordering) Option {
+	return func(c *Chat) {
+		c.order = o
+	}
+}
+
+// WithWriteTimeout sets maximum duration of writing single message to the
+// user connection. Zero means no timeout.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(c *Chat) {
+		c.writeTimeout = d
+	}
+}
+
+// WithWriteErrorClassifier sets func which decides how errors of writes to
+// user connections are handled. Default is ClassifyWriteError.
+func WithWriteErrorClassifier(fn func(u *User, err error) WriteErrorClass) Option {
+	return func(c *Chat) {
+		c.classify = fn
+	}
+}
+
+// WithReconnectBackoff enables detection of reconnect storms. When more
+// than threshold users are registered during window, "hello" notice
+// contains "retryAfter" param with random delay up to maxDelay in
+// milliseconds, which client should wait before its next reconnect.
+// Detection is disabled if window or maxDelay is not positive.
+func WithReconnectBackoff(threshold int, window, maxDelay time.Duration) Option {
+	return func(c *Chat) {
+		if window <= 0 || maxDelay <= 0 {
+			c.storm = nil
+			return
+		}
+		c.storm = &storm{
+			threshold: threshold,
+			window:    window,
+			delay:     maxDelay,
+			start:     time.Now(),
+		}
+	}
+}
+
+// WithMaxInFlight sets maximum number of registered method handlers (see
+// Chat.Handle()) running concurrently for single user. Requests over the
+// limit get error with ErrCodeBusy code. Default is 4, zero means no limit.
+func WithMaxInFlight(n int) Option {
+	return func(c *Chat) {
+		c.maxInFlight = n
+	}
+}
+
+// WithOnRemove sets func which is called after user is removed from chat
+// with reason of disconnect.
+func WithOnRemove(fn func(u *User, reason CloseReason)) Option {
+	return func(c *Chat) {
+		c.onRemove = fn
+	}
+}
+
+// WithIdempotency enables publishes with idempotency "key" param of up to
+// 64 bytes. Publish with key which was already published by the same user
+// within ttl is not broadcasted again. Keyed publishes with id get response
+// with "seq" of the broadcast and "duplicate" flag. Up to size last keys are
+// remembered per user.
+func WithIdempotency(size int, ttl time.Duration) Option {
+	return func(c *Chat) {
+		c.idempotencySize = size
+		c.idempotencyTTL = ttl
+	}
+}
+
+// WithBroadcastQueue sets size of the queue of broadcasts waiting for the
+// writer. If timeout is not zero, broadcasts which could not be queued
+// during timeout are shed with ErrBusy instead of blocking the caller.
+// Announcements always wait. Default size is 256 with no timeout, and size
+// not greater than zero means the default.
+func WithBroadcastQueue(size int, timeout time.Duration) Option {
+	return func(c *Chat) {
+		if size > 0 {
+			c.queueSize = size
+		}
+		c.queueTimeout = timeout
+	}
+}
+
+// WithSquelch enables dropping of publishes with the same author and text
+// (case and whitespace insensitive) sent within window, e.g. on client double
+// taps. Publishes with id get response with "seq" of the broadcast and
+// "duplicate" flag, so squelched ones get seq of the original publish.
+func WithSquelch(window time.Duration) Option {
+	return func(c *Chat) {
+		c.squelch = &squelch{
+			window: window,
+			seen:   make(map[uint64]published),
+		}
+	}
+}
+
+// WithMaxConnLifetime enables disconnecting of users after connection is open
+// for maxLifetime plus random jitter, e.g. to rebalance connections across
+// nodes. Users receive "reconnect" notice and are closed with
+// CloseCodeLifetime code.
+func WithMaxConnLifetime(maxLifetime, jitter time.Duration) Option {
+	return func(c *Chat) {
+		c.maxLifetime = maxLifetime
+		c.lifetimeJitter = jitter
+	}
+}
+
+// WithByteBudget limits number of bytes received from single user during
+// window. Users exceeding the budget are disconnected with CloseCodeRate
+// code.
+func WithByteBudget(limit int64, window time.Duration) Option {
+	return func(c *Chat) {
+		c.byteBudget = limit
+		c.budgetWindow = window
+	}
+}
+
+// WithMaxUnacked sets maximum number of broadcasts which user could leave
+// unacknowledged to be replayed on reconnect within ack window (see
+// WithAckWindow()). Sessions over the limit are not replayed, and client
+// gets "gap" notice to do full refresh instead. Zero means no limit.
+func WithMaxUnacked(n int) Option {
+	return func(c *Chat) {
+		c.maxUnacked = n
+	}
+}
+
+// WithSlowClient sets policy applied to direct sends (Chat.SendTo() and
+// "secure_publish") when recipient has at least maxPending broadcast writes
+// which are not done yet. Default is SlowWait.
+func WithSlowClient(policy SlowPolicy, maxPending int) Option {
+	return func(c *Chat) {
+		c.slowPolicy = policy
+		c.maxPending = maxPending
+	}
+}
+
+// WithNameHold sets duration during which name of removed user with identity
+// (see Chat.SetIdentity()) is reserved. Reserved names are not given to other
+// users, and user which sets the same identity within the duration gets its
+// name back.
+func WithNameHold(d time.Duration) Option {
+	return func(c *Chat) {
+		c.nameHold = d
+	}
+}
+
+// WithAdmin sets func which reports whether user could call admin methods,
+// e.g. "queue_report", "pin", "unpin" and "spectate". Admin methods are
+// forbidden for everyone by default.
+func WithAdmin(fn func(u *User) bool) Option {
+	return func(c *Chat) {
+		c.admin = fn
+	}
+}
+
+// WithGreetPolicy sets when new users are greeted. With policies other than
+// GreetAlways users are greeted only when Chat.SetIdentity() is called, so
+// users without identity are never greeted, and no "goodbye" is sent when
+// they leave. Default is GreetAlways.
+func WithGreetPolicy(p GreetPolicy) Option {
+	return func(c *Chat) {
+		c.greetPolicy = p
+	}
+}
+
+// WithRenameCooldown sets minimum duration between renames of single user.
+// Renames during cooldown get error with ErrCodeRateLimited code.
+func WithRenameCooldown(d time.Duration) Option {
+	return func(c *Chat) {
+		c.renameCooldown = d
+	}
+}
+
+// WithMaxPins sets maximum number of pinned messages (see Chat.Pin()).
+// Default is 10.
+func WithMaxPins(n int) Option {
+	return func(c *Chat) {
+		c.maxPins = n
+	}
+}
+
+// WithMaxDepth sets maximum nesting depth of json objects and arrays in
+// received requests. Requests nested deeper are not decoded and get error
+// with ErrCodeParse code. Zero means no limit.
+func WithMaxDepth(n int) Option {
+	return func(c *Chat) {
+		c.maxDepth = n
+	}
+}
+
+// WithMaxUsers limits number of users in chat. Connections over the limit
+// are put in the waiting room of up to maxWaiting users, where they receive
+// "queue_position" notices with their "position" whenever it changes and are
+// admitted in FIFO order as users leave. Connections over both limits are
+// rejected with ErrFull.
+func WithMaxUsers(max, maxWaiting int) Option {
+	return func(c *Chat) {
+		c.maxUsers = max
+		c.maxWaiting = maxWaiting
+	}
+}
+
+// WithWriterWorkers makes broadcasts written by n dedicated workers instead
+// of the pool. Each user has a ring of up to size broadcasts waiting to be
+// written; broadcasts to users with full ring are dropped. Broadcasts are
+// written to each user in order, so it overrides WithOrdering().
+func WithWriterWorkers(n, size int) Option {
+	return func(c *Chat) {
+		c.workers = n
+		c.ringSize = size
+	}
+}
+
+// WithReadReceipts enables read receipts for direct messages. Each
+// "secure_publish" gets an "id", which recipient passes to "read" method
+// within ttl. Author then receives "read_receipt" notice with "id", "reader"
+// and "time", but only if recipient enabled receipts for the conversation
+// by "receipts" method with "with" name and "enabled" flag.
+func WithReadReceipts(ttl time.Duration) Option {
+	return func(c *Chat) {
+		c.receipts = newReceipts(ttl)
+	}
+}
+
+// WithAuthLockout locks out client addresses after threshold authentication
+// failures, counted by failed "reauth" and Chat.AuthFailed(). Lockout lasts
+// base and doubles with every further failure up to max. Upgrade rejects
+// locked out clients with 429 status. Failures are forgotten after max of
+// no failures.
+func WithAuthLockout(threshold int, base, max time.Duration) Option {
+	return func(c *Chat) {
+		c.lockout = newLockout(threshold, base, max)
+	}
+}
+
+// WithServerTick makes chat broadcast "tick" notice with server "time" to
+// all users every interval. It is disabled by default.
+func WithServerTick(interval time.Duration) Option {
+	return func(c *Chat) {
+		c.tickInterval = interval
+	}
+}
+
+// WithRosterBatching replaces "greet" and "goodbye" broadcasts with single
+// "roster_changes" broadcast per window, with "joined" and "left" names.
+// User who joined and left within the window is not mentioned.
+func WithRosterBatching(window time.Duration) Option {
+	return func(c *Chat) {
+		c.roster.window = window
+	}
+}
+
+// WithWriteChunks makes frames larger than size written to connection by
+// chunks of size bytes, each with its own write deadline of timeout. Zero
+// timeout means write timeout (see WithWriteTimeout()). Frames are already
+// serialized, so chunking does not change websocket framing.
+func WithWriteChunks(size int, timeout time.Duration) Option {
+	return func(c *Chat) {
+		c.chunkSize = size
+		c.chunkTimeout = timeout
+	}
+}
+
+// WithReadProbe makes chat ping users which sent nothing for silence, and
+// disconnect them with CloseProbe reason unless any frame is received
+// within timeout. It detects half-open connections which would otherwise
+// surface only by a late write error. Unanswered probes are checked by the
+// sweeper every interval set by WithIdleTimeout(), or every timeout if idle
+// timeout is not set.
+func WithReadProbe(silence, timeout time.Duration) Option {
+	return func(c *Chat) {
+		c.probeSilence = silence
+		c.probeTimeout = timeout
+	}
+}
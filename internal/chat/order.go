@@ -0,0 +1,106 @@
+package chat
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Ordering defines ordering guarantees of broadcast delivery.
+type Ordering int
+
+const (
+	// OrderBestEffort schedules every write over the pool independently.
+	// It gives maximum throughput, but consecutive broadcasts could reach
+	// user in different order. It is the default.
+	OrderBestEffort Ordering = iota
+	// OrderPerUser writes broadcasts to each user in the order they were
+	// sent, using per-user serialized queue. Different users could receive
+	// messages at different times.
+	OrderPerUser
+	// OrderStrictGlobal writes all broadcasts from the single writer
+	// goroutine, so there is a total order of writes.
+	OrderStrictGlobal
+)
+
+// queue is a per-user queue of writes for OrderPerUser ordering.
+type queue struct {
+	mu      sync.Mutex
+	tasks   []func(write bool)
+	running bool
+}
+
+// deliver writes broadcast m to user u according to the chat ordering.
+func (c *Chat) deliver(m *message, u *User) {
+	// Personalized frames are not known until written, so size of the
+	// common frame is counted.
+	n := int64(len(m.frame(u.Protocol())))
+	if c.workers > 0 {
+		c.push(m, u, n)
+		return
+	}
+	c.flight.add(1)
+	u.flight.add(1)
+	atomic.AddInt64(&u.pendingBytes, n)
+	task := func(write bool) {
+		c.written(m, u, n, write)
+	}
+	switch c.order {
+	case OrderStrictGlobal:
+		task(true)
+	case OrderPerUser:
+		c.enqueue(u, task)
+	default:
+		if !c.schedule(func() { task(true) }) {
+			task(false)
+		}
+	}
+}
+
+// enqueue appends task to the user queue and schedules its processing.
+func (c *Chat) enqueue(u *User, task func(write bool)) {
+	u.q.mu.Lock()
+	u.q.tasks = append(u.q.tasks, task)
+	if u.q.running {
+		u.q.mu.Unlock()
+		return
+	}
+	u.q.running = true
+	u.q.mu.Unlock()
+
+	if !c.schedule(u.process) {
+		// Could not write now, drop queued writes.
+		for _, task := range u.takeQueue(true) {
+			task(false)
+		}
+	}
+}
+
+// process runs queued tasks until queue is empty.
+func (u *User) process() {
+	for {
+		tasks := u.takeQueue(false)
+		if tasks == nil {
+			return
+		}
+		for _, task := range tasks {
+			task(true)
+		}
+	}
+}
+
+// takeQueue takes queued tasks. If queue is empty or stop is true, queue
+// processing is marked as stopped.
+func (u *User) takeQueue(stop bool) []func(bool) {
+	u.q.mu.Lock()
+	defer u.q.mu.Unlock()
+
+	tasks := u.q.tasks
+	u.q.tasks = nil
+	if len(tasks) == 0 || stop {
+		u.q.running = false
+	}
+	if len(tasks) == 0 {
+		return nil
+	}
+	return tasks
+}
@@ -0,0 +1,84 @@
+package chat
+
+import "sync/atomic"
+
+// pause contains state of paused delivery to user.
+// Guarded by user mutex.
+type pause struct {
+	paused  bool
+	gap     bool
+	pending []pending
+}
+
+// pending is a broadcast frame buffered for paused user.
+type pending struct {
+	bts []byte
+	seq uint64
+}
+
+// Pause stops delivery of broadcasts to user. Broadcasts are buffered up to
+// the limit set by WithPauseBuffer() and written on Resume().
+func (u *User) Pause() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.pause.paused = true
+}
+
+// Resume writes broadcasts buffered while user was paused and continues
+// normal delivery. It returns true if some broadcasts were dropped because
+// buffer was full, and thus client should do full refresh.
+func (u *User) Resume() (gap bool, err error) {
+	// Hold io mutex while flushing so that new broadcasts are not written
+	// before buffered ones.
+	u.io.Lock()
+
+	u.mu.Lock()
+	p := u.pause
+	u.pause = pause{}
+	u.mu.Unlock()
+
+	atomic.AddInt64(&u.chat.buffered, -int64(len(p.pending)))
+	var n int
+	for _, f := range p.pending {
+		if n, err = u.writeRetry(f.bts); err != nil {
+			break
+		}
+		u.setLastSeq(f.seq)
+	}
+	u.io.Unlock()
+
+	if err != nil && u.chat.classifyWrite(u, n, err) == WriteErrorFatal {
+		u.writeFailed()
+	}
+	return p.gap, err
+}
+
+func (u *User) paused() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.pause.paused
+}
+
+// buffer buffers broadcast frame if user is paused. It returns false if user
+// is not paused and frame must be written as usual.
+func (u *User) buffer(bts []byte, seq uint64) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if !u.pause.paused {
+		return false
+	}
+	if seq == 0 {
+		// Unsequenced messages, e.g. ticks, are not worth buffering.
+		return true
+	}
+	if len(u.pause.pending) >= u.chat.pauseBuffer {
+		u.pause.gap = true
+	} else {
+		u.pause.pending = append(u.pause.pending, pending{bts, seq})
+		atomic.AddInt64(&u.chat.buffered, 1)
+	}
+	return true
+}
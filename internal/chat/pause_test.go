@@ -0,0 +1,56 @@
+package chat
+
+import (
+	"fmt"
+	"testing"
+)
+
+// timeoutError is a transient write error.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// flakyConn fails writes with errors from errs before recording them.
+type flakyConn struct {
+	recordConn
+	errs []error
+}
+
+func (c *flakyConn) Write(p []byte) (int, error) {
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return 0, err
+	}
+	return c.recordConn.Write(p)
+}
+
+func TestResumeWriteErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		errs   []error
+		err    bool
+		reason CloseReason
+	}{
+		{"transient", []error{timeoutError{}}, false, CloseLeft},
+		{"fatal", []error{fmt.Errorf("broken pipe")}, true, CloseWriteError},
+	} {
+		conn := &flakyConn{errs: test.errs}
+		u := &User{chat: NewChat(nil, WithPauseBuffer(4)), conn: conn}
+		u.Pause()
+		u.buffer([]byte("frame"), 1)
+
+		_, err := u.Resume()
+		if (err != nil) != test.err {
+			t.Errorf("%s: Resume() error is %v", test.name, err)
+		}
+		if r := u.CloseReason(); r != test.reason {
+			t.Errorf("%s: close reason is %v; want %v", test.name, r, test.reason)
+		}
+		if !test.err && (conn.String() != "frame" || u.LastSeq() != 1) {
+			t.Errorf("%s: written %q up to seq %d", test.name, conn.String(), u.LastSeq())
+		}
+	}
+}
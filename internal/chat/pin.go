@@ -0,0 +1,138 @@
+package chat
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Errors returned by Chat.Pin().
+var (
+	ErrNotPinnable = fmt.Errorf("chat: message is not retained or not public")
+	ErrTooManyPins = fmt.Errorf("chat: too many pinned messages")
+)
+
+// pinned is a pinned broadcast.
+type pinned struct {
+	seq uint64
+	req Request
+}
+
+// Pin pins broadcast with given sequence number and broadcasts "pin" event
+// with the message. Broadcast must be retained in history (see
+// WithHistory()) and sent to all users. Pinned messages are sent to
+// registered users in "pins" notice.
+func (c *Chat) Pin(seq uint64) error {
+	c.bmu.Lock()
+	entries := c.h.entries
+	c.bmu.Unlock()
+
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].seq >= seq
+	})
+	if i == len(entries) || entries[i].seq != seq {
+		return ErrNotPinnable
+	}
+	e := entries[i]
+	if e.where != nil || e.to != nil || e.personalize != nil {
+		return ErrNotPinnable
+	}
+
+	c.mu.Lock()
+	for _, p := range c.pins {
+		if p.seq == seq {
+			c.mu.Unlock()
+			return nil
+		}
+	}
+	if len(c.pins) >= c.maxPins {
+		c.mu.Unlock()
+		return ErrTooManyPins
+	}
+	c.pins = append(c.pins, pinned{seq, e.req})
+	c.mu.Unlock()
+
+	return c.Broadcast("pin", Object{
+		"seq":     seq,
+		"message": e.req,
+		"time":    timestamp(),
+	})
+}
+
+// Unpin unpins broadcast with given sequence number and broadcasts "unpin"
+// event. It returns false if broadcast is not pinned.
+func (c *Chat) Unpin(seq uint64) (bool, error) {
+	c.mu.Lock()
+	found := false
+	for i, p := range c.pins {
+		if p.seq == seq {
+			c.pins = append(c.pins[:i:i], c.pins[i+1:]...)
+			found = true
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	if !found {
+		return false, nil
+	}
+	return true, c.Broadcast("unpin", Object{
+		"seq":  seq,
+		"time": timestamp(),
+	})
+}
+
+// Pins returns pinned messages in the order of pinning.
+func (c *Chat) Pins() []Request {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ret := make([]Request, len(c.pins))
+	for i, p := range c.pins {
+		ret[i] = p.req
+	}
+	return ret
+}
+
+// pin handles "pin" and "unpin" requests.
+func (u *User) pin(req *Request) error {
+	if u.chat.admin == nil || !u.chat.admin(u) {
+		return u.writeErrorTo(req, Object{
+			"error": "forbidden",
+			"code":  ErrCodeForbidden,
+		})
+	}
+	seq, ok := req.Params.GetInt("seq")
+	if !ok || seq < 1 {
+		return u.writeErrorTo(req, Object{
+			"error": "bad params",
+			"code":  ErrCodeInvalidParams,
+		})
+	}
+	if req.Method == "unpin" {
+		found, err := u.chat.Unpin(uint64(seq))
+		if err != nil {
+			return err
+		}
+		if !found {
+			return u.writeErrorTo(req, Object{
+				"error": "not pinned",
+			})
+		}
+		return u.writeResultTo(req, nil)
+	}
+	switch err := u.chat.Pin(uint64(seq)); err {
+	case nil:
+		return u.writeResultTo(req, nil)
+	case ErrNotPinnable:
+		return u.writeErrorTo(req, Object{
+			"error": "not pinnable",
+			"code":  ErrCodeInvalidParams,
+		})
+	case ErrTooManyPins:
+		return u.writeErrorTo(req, Object{
+			"error": "too many pins",
+		})
+	default:
+		return err
+	}
+}
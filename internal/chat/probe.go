@@ -0,0 +1,67 @@
+package chat
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gobwas/ws"
+)
+
+// prober periodically pings users which are silent for longer than probe
+// silence until chat is shut down.
+func (c *Chat) prober() {
+	t := time.NewTicker(c.probeSilence)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-c.done:
+			return
+		}
+		c.mu.RLock()
+		us := c.us
+		c.mu.RUnlock()
+
+		silent := time.Now().Add(-c.probeSilence).UnixNano()
+		for _, u := range us {
+			if atomic.LoadInt64(&u.active) > silent {
+				continue
+			}
+			if !atomic.CompareAndSwapUint32(&u.probing, 0, 1) {
+				continue
+			}
+			if !c.schedule(u.probe) {
+				atomic.StoreUint32(&u.probing, 0)
+			}
+		}
+	}
+}
+
+// probe sends ping to user. Sweeper disconnects user unless any frame, pong
+// or data, is received within probe timeout. Probe does not touch the read
+// side of connection, so it does not interfere with reads in progress;
+// closing the connection unblocks them.
+func (u *User) probe() {
+	sent := time.Now().UnixNano()
+	if err := u.writeRaw(ws.CompiledPing); err != nil {
+		// Failed write is handled by writeRaw.
+		atomic.StoreUint32(&u.probing, 0)
+		return
+	}
+	atomic.StoreInt64(&u.probed, sent)
+}
+
+// probeExpired reports whether read probe sent to user was not answered
+// until deadline. Answered probe is cleared, so user could be probed again.
+func (u *User) probeExpired(active, deadline int64) bool {
+	sent := atomic.LoadInt64(&u.probed)
+	if sent == 0 {
+		return false
+	}
+	if active >= sent {
+		atomic.StoreInt64(&u.probed, 0)
+		atomic.StoreUint32(&u.probing, 0)
+		return false
+	}
+	return sent <= deadline
+}
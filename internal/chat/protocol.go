@@ -0,0 +1,133 @@
+package chat
+
+import "sync"
+
+// Supported protocol versions.
+const (
+	// ProtocolV1 is the default wire format.
+	ProtocolV1 = 1
+	// ProtocolV2 wraps every message sent to client in Envelope.
+	ProtocolV2 = 2
+)
+
+// Envelope wraps messages sent to ProtocolV2 clients.
+type Envelope struct {
+	Version int         `json:"v"`
+	Data    interface{} `json:"data"`
+}
+
+// message contains the same message framed for each protocol version.
+type message struct {
+	// v2 is framed on first use, so broadcasts are not framed twice while
+	// there are no ProtocolV2 clients.
+	v1  []byte
+	v2  *lazyFrame
+	seq uint64
+	req Request
+	// ready is closed when broadcast queued before framing is framed. Nil
+	// means message is framed before it is queued.
+	ready chan struct{}
+
+	target
+}
+
+// target selects recipients of broadcast. All fields are optional.
+type target struct {
+	// from is the user on whose behalf message is sent. Users who blocked
+	// the sender do not receive the message.
+	from *User
+	// where selects recipients of message.
+	where func(*User) bool
+	// to is explicit list of recipients used instead of all users.
+	to []*User
+	// personalize modifies copy of params for each recipient. Messages with
+	// personalize are framed per recipient.
+	personalize func(u *User, params Object)
+}
+
+// allows reports whether message could be written to user u.
+// Note that it does not check explicit list of recipients.
+func (t target) allows(u *User) bool {
+	if t.from != nil && u.blocks(t.from) {
+		return false
+	}
+	if t.where != nil && !t.where(u) {
+		return false
+	}
+	return true
+}
+
+// deliversTo reports whether message should be written to user u.
+func (t target) deliversTo(u *User) bool {
+	if !t.allows(u) {
+		return false
+	}
+	if t.to == nil {
+		return true
+	}
+	for _, v := range t.to {
+		if v == u {
+			return true
+		}
+	}
+	return false
+}
+
+// newMessage frames x for ProtocolV1. Other versions are framed on first
+// use.
+func (c *Chat) newMessage(x interface{}) (message, error) {
+	bts, err := c.frameVersion(ProtocolV1, x)
+	if err != nil {
+		return message{}, err
+	}
+	return message{
+		v1: bts,
+		v2: &lazyFrame{build: func() ([]byte, error) {
+			return c.frameVersion(ProtocolV2, x)
+		}},
+	}, nil
+}
+
+// frameVersion encodes and frames x for given protocol version.
+func (c *Chat) frameVersion(version int, x interface{}) ([]byte, error) {
+	y, err := c.encode(version, x)
+	if err != nil {
+		return nil, err
+	}
+	return frame(y)
+}
+
+// frame returns message framed for given protocol version. It returns nil if
+// message could not be framed.
+func (m message) frame(version int) []byte {
+	if version == ProtocolV1 {
+		return m.v1
+	}
+	return m.v2.get()
+}
+
+// lazyFrame is a frame built once on first use.
+type lazyFrame struct {
+	once  sync.Once
+	build func() ([]byte, error)
+	bts   []byte
+}
+
+func (f *lazyFrame) get() []byte {
+	f.once.Do(func() {
+		// Error means nil frame, which is not written.
+		f.bts, _ = f.build()
+	})
+	return f.bts
+}
+
+// wrap prepares x to be sent with given protocol version.
+func wrap(version int, x interface{}) interface{} {
+	if version == ProtocolV1 {
+		return x
+	}
+	return Envelope{
+		Version: version,
+		Data:    x,
+	}
+}
@@ -0,0 +1,56 @@
+package chat
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// QueueDepth describes outbound backlog of single user.
+type QueueDepth struct {
+	Name string `json:"name"`
+	// Depth is a number of broadcasts scheduled or buffered for user but not
+	// written yet.
+	Depth int `json:"depth"`
+	// BytesPending is a size of such broadcasts. It is approximate for
+	// personalized broadcasts.
+	BytesPending int `json:"bytesPending"`
+}
+
+// QueueReport returns outbound backlog of users sorted by depth, deepest
+// first, e.g. to find slow clients causing broadcast lag. Users without
+// backlog are omitted. It does not block the writer.
+func (c *Chat) QueueReport() []QueueDepth {
+	c.mu.RLock()
+	us := c.us
+	names := make([]string, len(us))
+	for i, u := range us {
+		names[i] = u.name
+	}
+	c.mu.RUnlock()
+
+	var ret []QueueDepth
+	for i, u := range us {
+		depth := u.flight.pending()
+		bytes := int(atomic.LoadInt64(&u.pendingBytes))
+
+		u.mu.Lock()
+		depth += len(u.pause.pending)
+		for _, p := range u.pause.pending {
+			bytes += len(p.bts)
+		}
+		u.mu.Unlock()
+
+		if depth == 0 {
+			continue
+		}
+		ret = append(ret, QueueDepth{
+			Name:         names[i],
+			Depth:        depth,
+			BytesPending: bytes,
+		})
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Depth > ret[j].Depth
+	})
+	return ret
+}
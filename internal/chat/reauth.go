@@ -0,0 +1,80 @@
+package chat
+
+import (
+	"time"
+)
+
+// reauth contains state of periodic user re-authentication.
+// Guarded by user mutex.
+type reauth struct {
+	timer   *time.Timer
+	pending bool
+	last    time.Time
+}
+
+// LastReauth returns time of the last successful re-authentication of user.
+// Registration time is returned if user did not re-authenticate yet.
+func (u *User) LastReauth() time.Time {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.reauth.last
+}
+
+// startReauth starts periodic re-authentication of user if it is enabled.
+func (u *User) startReauth() {
+	if u.chat.reauthInterval <= 0 {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.reauth.last = time.Now()
+	u.reauth.timer = time.AfterFunc(u.chat.reauthInterval, u.requestReauth)
+}
+
+func (u *User) stopReauth() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.reauth.timer != nil {
+		u.reauth.timer.Stop()
+	}
+}
+
+// requestReauth asks client for a fresh token. If previous request is not
+// answered, user is disconnected.
+func (u *User) requestReauth() {
+	u.mu.Lock()
+	expired := u.reauth.pending
+	if !expired {
+		u.reauth.pending = true
+		u.reauth.timer.Reset(u.chat.reauthTimeout)
+	}
+	u.mu.Unlock()
+
+	if expired {
+		u.closeFor(CloseReauth, "reauth timeout")
+		u.chat.Remove(u)
+		return
+	}
+	u.writeNotice("reauth", nil)
+}
+
+// completeReauth verifies token sent by client. Without verify func every
+// token is rejected.
+func (u *User) completeReauth(token string) bool {
+	if verify := u.chat.reauthVerify; verify == nil || !verify(u, token) {
+		return false
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.reauth.pending = false
+	u.reauth.last = time.Now()
+	u.reauth.timer.Reset(u.chat.reauthInterval)
+
+	return true
+}
@@ -0,0 +1,146 @@
+package chat
+
+import (
+	"sync"
+	"time"
+)
+
+// maxReceipts is the maximum number of direct messages awaiting read
+// receipt.
+const maxReceipts = 10000
+
+// receipts tracks direct messages awaiting read receipt.
+type receipts struct {
+	mu      sync.Mutex
+	seq     uint64
+	ttl     time.Duration
+	pending map[uint64]receipt
+}
+
+type receipt struct {
+	author    *User
+	recipient *User
+	expires   time.Time
+}
+
+func newReceipts(ttl time.Duration) *receipts {
+	return &receipts{
+		ttl:     ttl,
+		pending: make(map[uint64]receipt),
+	}
+}
+
+// add registers direct message from author to recipient and returns its id.
+func (r *receipts) add(author, recipient *User) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if len(r.pending) >= maxReceipts {
+		r.expire(now)
+	}
+	r.seq++
+	if len(r.pending) < maxReceipts {
+		r.pending[r.seq] = receipt{
+			author:    author,
+			recipient: recipient,
+			expires:   now.Add(r.ttl),
+		}
+	}
+	return r.seq
+}
+
+// take removes message with given id read by u and returns its author. It
+// returns false if there is no such message, it has expired or it was not
+// sent to u.
+func (r *receipts) take(id uint64, u *User) (*User, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	x, has := r.pending[id]
+	if !has || x.recipient != u {
+		return nil, false
+	}
+	delete(r.pending, id)
+	if time.Now().After(x.expires) {
+		return nil, false
+	}
+	return x.author, true
+}
+
+// expire removes expired messages.
+// mutex must be held.
+func (r *receipts) expire(now time.Time) {
+	for id, x := range r.pending {
+		if now.After(x.expires) {
+			delete(r.pending, id)
+		}
+	}
+}
+
+// sendsReceipts reports whether u agreed to send read receipts to user with
+// given name.
+func (u *User) sendsReceipts(name string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.receipts[name]
+}
+
+// setReceipts handles "receipts" request, which enables or disables read
+// receipts in conversation with user given by "with" param.
+func (u *User) setReceipts(req *Request) error {
+	with, ok1 := req.Params.GetString("with")
+	enabled, ok2 := req.Params.GetBool("enabled")
+	if !ok1 || !ok2 {
+		return u.writeErrorTo(req, Object{
+			"error": "bad params",
+			"code":  ErrCodeInvalidParams,
+		})
+	}
+	u.mu.Lock()
+	if enabled {
+		if u.receipts == nil {
+			u.receipts = make(map[string]bool)
+		}
+		u.receipts[with] = true
+	} else {
+		delete(u.receipts, with)
+	}
+	u.mu.Unlock()
+
+	return u.writeResultTo(req, nil)
+}
+
+// read handles "read" request, which marks direct message with given "id"
+// as read. Author of the message receives "read_receipt" notice if u enabled
+// receipts in conversation with them.
+func (u *User) read(req *Request) error {
+	id, ok := req.Params.GetInt("id")
+	if !ok || id < 1 {
+		return u.writeErrorTo(req, Object{
+			"error": "bad params",
+			"code":  ErrCodeInvalidParams,
+		})
+	}
+	author, ok := u.chat.receipts.take(uint64(id), u)
+	if !ok {
+		return u.writeErrorTo(req, Object{
+			"error": "no such message",
+		})
+	}
+	c := u.chat
+	c.mu.RLock()
+	name := author.name
+	online := c.ns[name] == author
+	reader := u.name
+	c.mu.RUnlock()
+
+	if online && u.sendsReceipts(name) {
+		author.writeNotice("read_receipt", Object{
+			"id":     uint64(id),
+			"reader": reader,
+			"time":   timestamp(),
+		})
+	}
+	return u.writeResultTo(req, nil)
+}
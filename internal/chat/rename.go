@@ -0,0 +1,23 @@
+package chat
+
+import "time"
+
+// canRename reports whether rename cooldown of user is over.
+func (u *User) canRename() bool {
+	d := u.chat.renameCooldown
+	if d <= 0 {
+		return true
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return time.Since(u.lastRename) >= d
+}
+
+// renamed starts rename cooldown of user.
+func (u *User) renamed() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.lastRename = time.Now()
+}
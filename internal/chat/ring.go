@@ -0,0 +1,127 @@
+package chat
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// readyQueue is the capacity of the queue of users ready for writer workers.
+const readyQueue = 1024
+
+// queued is a broadcast waiting in the user ring.
+type queued struct {
+	m *message
+	n int64 // Size counted in user pending bytes.
+}
+
+// ring is a bounded queue of broadcasts waiting to be written to user by
+// writer workers (see WithWriterWorkers()).
+type ring struct {
+	mu    sync.Mutex
+	buf   []queued
+	head  int
+	n     int
+	ready bool // Set while user is queued for workers or being drained.
+}
+
+// push appends q to the ring of given capacity. It returns false if ring is
+// full. Schedule is true if user must be queued for workers.
+func (r *ring) push(q queued, size int) (ok, schedule bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.buf == nil {
+		r.buf = make([]queued, size)
+	}
+	if r.n == len(r.buf) {
+		return false, false
+	}
+	r.buf[(r.head+r.n)%len(r.buf)] = q
+	r.n++
+	if r.ready {
+		return true, false
+	}
+	r.ready = true
+	return true, true
+}
+
+// take moves queued broadcasts to dst. If ring is empty, it is marked as not
+// ready.
+func (r *ring) take(dst []queued) []queued {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for ; r.n > 0; r.n-- {
+		dst = append(dst, r.buf[r.head])
+		r.buf[r.head] = queued{}
+		r.head = (r.head + 1) % len(r.buf)
+	}
+	if len(dst) == 0 {
+		r.ready = false
+	}
+	return dst
+}
+
+// push queues broadcast m for writer workers.
+func (c *Chat) push(m *message, u *User, n int64) {
+	c.flight.add(1)
+	u.flight.add(1)
+	atomic.AddInt64(&u.pendingBytes, n)
+
+	ok, schedule := u.ring.push(queued{m, n}, c.ringSize)
+	if !ok {
+		atomic.AddUint64(&c.dropped, 1)
+		c.written(m, u, n, false)
+		return
+	}
+	if schedule {
+		c.ready <- u
+	}
+}
+
+// worker writes broadcasts queued in rings of ready users. User is drained
+// by one worker at a time, so broadcasts are written in order. Users with
+// more broadcasts are requeued after each batch for fairness.
+func (c *Chat) worker() {
+	var batch []queued
+	for {
+		select {
+		case u := <-c.ready:
+			batch = c.drainRing(u, batch)
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *Chat) drainRing(u *User, batch []queued) []queued {
+	for {
+		batch = u.ring.take(batch[:0])
+		if len(batch) == 0 {
+			return batch
+		}
+		for i, q := range batch {
+			c.written(q.m, u, q.n, true)
+			batch[i] = queued{}
+		}
+		select {
+		case c.ready <- u:
+			return batch
+		default:
+			// Queue is full, so keep draining instead of blocking while
+			// other workers could block too.
+		}
+	}
+}
+
+// written writes broadcast m to user if write is true and finishes its
+// accounting.
+func (c *Chat) written(m *message, u *User, n int64, write bool) {
+	defer c.flight.done()
+	defer u.flight.done()
+	defer atomic.AddInt64(&u.pendingBytes, -n)
+
+	if write && u.writeRaw(c.frameFor(*m, u)) == nil {
+		u.setLastSeq(m.seq)
+	}
+}
@@ -0,0 +1,46 @@
+package chat_test
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/suryatresna/multiplayerengine/internal/chat"
+)
+
+// BenchmarkBroadcast broadcasts to many users written by the pool or by
+// writer workers.
+func BenchmarkBroadcast(b *testing.B) {
+	for _, n := range []int{10000, 50000} {
+		for _, bc := range []struct {
+			name string
+			opts []chat.Option
+		}{
+			{"pool", nil},
+			{"workers", []chat.Option{chat.WithWriterWorkers(runtime.GOMAXPROCS(0)*4, 64)}},
+		} {
+			b.Run(strconv.Itoa(n)+"/"+bc.name, func(b *testing.B) {
+				opts := append([]chat.Option{chat.WithGreetPolicy(chat.GreetSilent)}, bc.opts...)
+				c := chat.NewChat(newPool(), opts...)
+				for i := 0; i < n; i++ {
+					if _, err := c.RegisterConn(discardConn{}); err != nil {
+						b.Fatal(err)
+					}
+				}
+				flush(b, c)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := c.Broadcast("publish", chat.Object{"text": "hello"}); err != nil {
+						b.Fatal(err)
+					}
+					flush(b, c)
+				}
+				b.StopTimer()
+				c.Shutdown(context.Background())
+			})
+		}
+	}
+}
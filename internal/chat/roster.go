@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"sync"
+	"time"
+)
+
+// roster batches greet and goodbye broadcasts into "roster_changes" ones.
+type roster struct {
+	mu        sync.Mutex
+	window    time.Duration
+	joined    []string
+	left      []string
+	scheduled bool
+}
+
+// rosterChange records that user with given name joined or left the chat.
+// The first change in the window schedules the batch broadcast.
+func (c *Chat) rosterChange(name string, joined bool) {
+	r := &c.roster
+	r.mu.Lock()
+	scheduled := r.scheduled
+	r.scheduled = true
+	if joined {
+		r.joined, r.left = netChange(r.joined, r.left, name)
+	} else {
+		r.left, r.joined = netChange(r.left, r.joined, name)
+	}
+	r.mu.Unlock()
+
+	if !scheduled {
+		time.AfterFunc(r.window, c.flushRoster)
+	}
+}
+
+// netChange adds name to add unless it is in cancel, in which case the
+// events cancel each other and name is removed from cancel.
+func netChange(add, cancel []string, name string) ([]string, []string) {
+	for i, v := range cancel {
+		if v == name {
+			return add, append(cancel[:i], cancel[i+1:]...)
+		}
+	}
+	return appendUnique(add, name), cancel
+}
+
+func (c *Chat) flushRoster() {
+	r := &c.roster
+	r.mu.Lock()
+	joined, left := r.joined, r.left
+	r.joined, r.left = nil, nil
+	r.scheduled = false
+	r.mu.Unlock()
+
+	if len(joined) == 0 && len(left) == 0 {
+		return
+	}
+	if joined == nil {
+		joined = []string{}
+	}
+	if left == nil {
+		left = []string{}
+	}
+	c.broadcastRoster("roster_changes", Object{
+		"joined": joined,
+		"left":   left,
+		"time":   timestamp(),
+	})
+}
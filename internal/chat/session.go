@@ -0,0 +1,93 @@
+package chat
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// SessionPolicy defines what happens when user with already connected
+// identity is authenticated again.
+type SessionPolicy int
+
+const (
+	// SessionMulti allows any number of connections per identity.
+	SessionMulti SessionPolicy = iota
+	// SessionSingle rejects new connection.
+	SessionSingle
+	// SessionTakeover disconnects previous connection. New one takes over
+	// its name and history position.
+	SessionTakeover
+)
+
+// ErrSessionExists returned by SetIdentity when identity is already
+// connected and SessionSingle policy is used.
+var ErrSessionExists = fmt.Errorf("chat: session already exists")
+
+// Identity returns identity set by SetIdentity.
+func (u *User) Identity() string {
+	u.chat.mu.RLock()
+	defer u.chat.mu.RUnlock()
+
+	return u.identity
+}
+
+// SetIdentity marks user as authenticated with given identity, e.g. account
+// id. If there is another user with the same identity, session policy set by
+// WithSessionPolicy() is applied. If identity reconnects within ack window
+// (see WithAckWindow()), broadcasts after the last acknowledged one are
+// replayed. If name of the identity is held (see WithNameHold()), user is
+// renamed back to it. With SessionSingle policy ErrSessionExists
+// is returned and caller should close the connection.
+func (c *Chat) SetIdentity(u *User, identity string) error {
+	c.mu.Lock()
+	if c.ns[u.name] != u {
+		c.mu.Unlock()
+		return ErrNoSuchUser
+	}
+	old := c.ids[identity]
+	if old == u || c.session == SessionMulti {
+		old = nil
+	}
+	if old != nil && c.session == SessionSingle {
+		c.mu.Unlock()
+		return ErrSessionExists
+	}
+	var prev string
+	u.identity = identity
+	if old != nil {
+		old.setCloseReason(CloseTakeover)
+		c.remove(old)
+		prev = u.name
+		c.claimName(u, old.name)
+		atomic.StoreUint64(&u.lastSeq, old.LastSeq())
+	}
+	c.ids[identity] = u
+	c.setAuthenticated(u, true)
+	renamed := old != nil
+	if name, has := c.held[identity]; has && !renamed {
+		prev = u.name
+		renamed = c.claimName(u, name)
+	}
+	s, resumed := c.takeSession(identity)
+	c.mu.Unlock()
+
+	if resumed {
+		c.replayTo(u, s)
+	}
+	c.greetIdentity(u, resumed)
+	if old != nil {
+		old.closeFor(CloseTakeover, "session taken over")
+		c.removed(old)
+		c.promote()
+	}
+	if !renamed {
+		return nil
+	}
+	c.presence(prev, false)
+	c.presence(u.name, true)
+	return c.broadcastRoster("rename", Object{
+		"prev": prev,
+		"name": u.name,
+		"time": timestamp(),
+	})
+}
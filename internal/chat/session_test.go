@@ -0,0 +1,35 @@
+package chat_test
+
+import (
+	"testing"
+
+	"github.com/suryatresna/multiplayerengine/internal/chat"
+)
+
+func TestTakeoverStaleRemove(t *testing.T) {
+	c := chat.NewChat(nil, chat.WithSessionPolicy(chat.SessionTakeover))
+	old := connect(t, c)
+	bystander := connect(t, c)
+	cur := connect(t, c)
+	if err := c.SetIdentity(old.u, "id"); err != nil {
+		t.Fatal(err)
+	}
+	name := c.UserInfo(old.u)["name"].(string)
+	if err := c.SetIdentity(cur.u, "id"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read loop of the old connection removes it once more.
+	c.Remove(old.u)
+	c.Remove(old.u)
+
+	if u, ok := c.Lookup(name); !ok || u != cur.u {
+		t.Errorf("Lookup(%q) = %v, %t; want the new session", name, u, ok)
+	}
+	if _, ok := c.Lookup(c.UserInfo(bystander.u)["name"].(string)); !ok {
+		t.Error("bystander is not found")
+	}
+	if n := c.Count(); n != 2 {
+		t.Errorf("Count() = %d; want 2", n)
+	}
+}
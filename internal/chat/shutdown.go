@@ -0,0 +1,61 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ErrShuttingDown returned by Receive and broadcasts when chat is shut down.
+var ErrShuttingDown = fmt.Errorf("chat: server is shutting down")
+
+// Shutdown shuts chat down. New connections are rejected, pending writes are
+// flushed until ctx is done, and then all connections are closed with
+// CloseCodeDraining code. Blocked Receive calls return ErrShuttingDown, so
+// read loops could exit. Broadcasts return ErrShuttingDown too.
+func (c *Chat) Shutdown(ctx context.Context) error {
+	c.Drain(0)
+	c.closeDone.Do(func() {
+		close(c.done)
+	})
+	err := c.Flush(ctx)
+	// Writer and workers exit, so chat does not leak goroutines.
+	c.closeStop.Do(func() {
+		close(c.stop)
+	})
+
+	c.mu.RLock()
+	us := append(append([]*User(nil), c.us...), c.wait...)
+	c.mu.RUnlock()
+
+	for _, u := range us {
+		// Unblock reader which holds io mutex.
+		u.setReadDeadline(time.Now())
+		u.closeFor(CloseDraining, "server shutting down")
+	}
+
+	removed := make([]*User, 0, len(us))
+	c.mu.Lock()
+	for _, u := range us {
+		if c.remove(u) {
+			removed = append(removed, u)
+		}
+	}
+	c.wait = nil
+	c.mu.Unlock()
+
+	for _, u := range removed {
+		c.removed(u)
+	}
+	return err
+}
+
+// shuttingDown reports whether Shutdown was called.
+func (c *Chat) shuttingDown() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
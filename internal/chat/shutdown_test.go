@@ -0,0 +1,77 @@
+package chat_test
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/suryatresna/multiplayerengine/internal/chat"
+	"github.com/suryatresna/multiplayerengine/internal/chat/chattest"
+)
+
+func TestShutdownUnblocksReceive(t *testing.T) {
+	const (
+		n       = 8
+		timeout = 200 * time.Millisecond
+	)
+	c := chat.NewChat(nil)
+
+	// Read loops block in Receive, as without epoll, so pending writes
+	// could not be flushed and Shutdown waits for ctx deadline.
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		srv, cli := net.Pipe()
+		cl := chattest.NewClient(cli)
+		t.Cleanup(func() { cl.Close() })
+		u, err := c.RegisterConn(srv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for {
+				if err := u.Receive(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	c.Shutdown(ctx)
+
+	tm := time.After(timeout)
+	for i := 0; i < n; i++ {
+		select {
+		case err := <-errs:
+			if err != chat.ErrShuttingDown {
+				t.Errorf("Receive() error: %v; want %v", err, chat.ErrShuttingDown)
+			}
+		case <-tm:
+			t.Fatalf("%d of %d read loops returned in %s", i, n, time.Since(start))
+		}
+	}
+}
+
+func TestShutdownStopsWriter(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		c := chat.NewChat(nil, chat.WithWriterWorkers(2, 8))
+		c.Shutdown(context.Background())
+		if err := c.Broadcast("x", nil); err != chat.ErrShuttingDown {
+			t.Fatalf("Broadcast() after Shutdown error is %v; want %v", err, chat.ErrShuttingDown)
+		}
+	}
+	// Goroutines exit asynchronously.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines left after Shutdown; had %d", n, before)
+	}
+}
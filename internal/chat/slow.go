@@ -0,0 +1,35 @@
+package chat
+
+import "fmt"
+
+// ErrSlowRecipient returned by SendTo when recipient has too many pending
+// writes and slow client policy is not SlowWait.
+var ErrSlowRecipient = fmt.Errorf("chat: recipient is too slow")
+
+// SlowPolicy defines how direct sends to slow recipients are handled.
+type SlowPolicy int
+
+const (
+	// SlowWait waits until write to recipient is done.
+	SlowWait SlowPolicy = iota
+	// SlowDrop drops the message.
+	SlowDrop
+	// SlowDisconnect drops the message and disconnects recipient.
+	SlowDisconnect
+)
+
+// slow reports whether user has too many pending writes. If so, slow client
+// policy is applied.
+func (c *Chat) slow(u *User) bool {
+	if c.slowPolicy == SlowWait || u.flight.pending() < c.maxPending {
+		return false
+	}
+	if c.slowPolicy == SlowDisconnect {
+		// Connection is not closed gracefully since pending writes could
+		// hold the io mutex for long.
+		u.setCloseReason(CloseSlow)
+		u.conn.Close()
+		go c.Remove(u)
+	}
+	return true
+}
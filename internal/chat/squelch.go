@@ -0,0 +1,96 @@
+package chat
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// squelch drops identical publishes of the same author sent within short
+// window, e.g. on client double taps.
+type squelch struct {
+	mu     sync.Mutex
+	window time.Duration
+	seen   map[uint64]published
+}
+
+// squelchPrune is the number of remembered publishes after which expired
+// ones are pruned.
+const squelchPrune = 1024
+
+// hash returns hash of author and normalized text of publish params. It
+// returns false if there is no text.
+func (s *squelch) hash(u *User, params Object) (uint64, bool) {
+	text, ok := params.GetString("text")
+	if !ok {
+		text, ok = params.GetString("body")
+	}
+	if !ok {
+		return 0, false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(strconv.FormatUint(uint64(u.id), 10)))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.ToLower(strings.Join(strings.Fields(text), " "))))
+	return h.Sum64(), true
+}
+
+// lookup returns sequence number of the same publish sent within window.
+func (s *squelch) lookup(h uint64) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, has := s.seen[h]
+	if !has || time.Since(p.at) > s.window {
+		return 0, false
+	}
+	return p.seq, true
+}
+
+func (s *squelch) store(h uint64, seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.seen) >= squelchPrune {
+		for k, p := range s.seen {
+			if time.Since(p.at) > s.window {
+				delete(s.seen, k)
+			}
+		}
+	}
+	s.seen[h] = published{
+		seq: seq,
+		at:  time.Now(),
+	}
+}
+
+// publish broadcasts publish on behalf of user. If squelch is enabled and
+// the same publish was sent recently, broadcast is not repeated and
+// sequence number of the original one is returned with dup set to true.
+func (u *User) publish(ctx context.Context, params Object) (seq uint64, dup bool, err error) {
+	c := u.chat
+	s := c.squelch
+	var (
+		h      uint64
+		hashed bool
+	)
+	if s != nil {
+		h, hashed = s.hash(u, params)
+	}
+	if hashed {
+		if seq, has := s.lookup(h); has {
+			return seq, true, nil
+		}
+	}
+	seq, err = c.broadcastSeq(ctx, c.out, target{from: u}, "publish", params)
+	if err != nil {
+		return 0, false, err
+	}
+	if hashed {
+		s.store(h, seq)
+	}
+	return seq, false, nil
+}
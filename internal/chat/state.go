@@ -0,0 +1,132 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// stateVersion is the version of format used by ExportState.
+const stateVersion = 1
+
+// stateHold is how long imported names are held when name hold is not set
+// by WithNameHold().
+const stateHold = time.Minute
+
+// ErrStateVersion returned by ImportState when state has unknown version.
+var ErrStateVersion = fmt.Errorf("chat: unsupported state version")
+
+// ErrNotEmpty returned by ImportState when chat already has users.
+var ErrNotEmpty = fmt.Errorf("chat: chat is not empty")
+
+type state struct {
+	Version int            `json:"version"`
+	NextID  uint           `json:"nextId"`
+	Seq     uint64         `json:"seq"`
+	Users   []userState    `json:"users"`
+	History []historyState `json:"history"`
+}
+
+type userState struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	Avatar   string `json:"avatar,omitempty"`
+	Identity string `json:"identity,omitempty"`
+	Acked    uint64 `json:"acked,omitempty"`
+}
+
+type historyState struct {
+	Seq     uint64  `json:"seq"`
+	Request Request `json:"request"`
+}
+
+// ExportState returns snapshot of chat state as versioned JSON. It could be
+// passed to ImportState of another Chat, e.g. during blue-green deploy.
+// Only broadcasts sent to all users are exported from history.
+func (c *Chat) ExportState() ([]byte, error) {
+	c.mu.RLock()
+	s := state{
+		Version: stateVersion,
+		NextID:  c.seq,
+		Users:   make([]userState, len(c.us)),
+	}
+	for i, u := range c.us {
+		s.Users[i] = userState{
+			ID:       u.id,
+			Name:     u.name,
+			Avatar:   u.avatar,
+			Identity: u.identity,
+			Acked:    u.Acked(),
+		}
+	}
+	c.mu.RUnlock()
+
+	c.bmu.Lock()
+	s.Seq = c.bseq
+	entries := c.h.entries
+	c.bmu.Unlock()
+
+	s.History = make([]historyState, 0, len(entries))
+	for _, e := range entries {
+		if e.where != nil || e.to != nil || e.personalize != nil {
+			continue
+		}
+		s.History = append(s.History, historyState{e.seq, e.req})
+	}
+
+	return json.Marshal(s)
+}
+
+// ImportState seeds fresh chat with state returned by ExportState. User ids
+// and broadcast sequence numbers continue from the exported ones, and
+// history is restored. Connections can not be migrated, so names of
+// exported users with identity are held for the name hold duration (see
+// WithNameHold()), or a minute if it is not set, and users reclaim them by
+// Chat.SetIdentity() on reconnect. Within ack window (see WithAckWindow())
+// broadcasts after the last acknowledged ones are replayed to them.
+func (c *Chat) ImportState(data []byte) error {
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s.Version != stateVersion {
+		return ErrStateVersion
+	}
+
+	c.mu.Lock()
+	if len(c.us) > 0 {
+		c.mu.Unlock()
+		return ErrNotEmpty
+	}
+	c.seq = s.NextID
+	d := c.nameHold
+	if d <= 0 {
+		d = stateHold
+	}
+	for _, u := range s.Users {
+		if u.Identity == "" {
+			continue
+		}
+		c.hold(u.Name, u.Identity, d)
+		if c.ackWindow > 0 {
+			c.ss[u.Identity] = session{
+				acked:   u.Acked,
+				expires: time.Now().Add(c.ackWindow),
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	// Broadcast mutex must not be taken with chat mutex held.
+	c.bmu.Lock()
+	c.bseq = s.Seq
+	for _, h := range s.History {
+		c.h.append(entry{
+			seq: h.Seq,
+			req: h.Request,
+		})
+	}
+	c.bmu.Unlock()
+
+	return nil
+}
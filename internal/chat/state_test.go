@@ -0,0 +1,65 @@
+package chat_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/suryatresna/multiplayerengine/internal/chat"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	opts := []chat.Option{
+		chat.WithHistory(10),
+		chat.WithAckWindow(time.Minute),
+	}
+	src := chat.NewChat(nil, opts...)
+	p := connect(t, src)
+	if err := src.SetIdentity(p.u, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	name := src.UserInfo(p.u)["name"]
+	for _, text := range []string{"one", "two"} {
+		if err := src.Broadcast("news", chat.Object{"text": text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush(t, src)
+	data, err := src.ExportState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := chat.NewChat(nil, opts...)
+	if err := dst.ImportState(data); err != nil {
+		t.Fatal(err)
+	}
+	// Other user could not take the held name.
+	other := connect(t, dst)
+	if _, err := other.cl.Call(callContext(t), "rename", chat.Object{"name": name}); err == nil {
+		t.Errorf("held name %v was taken by other user", name)
+	}
+
+	q := connect(t, dst)
+	if err := dst.SetIdentity(q.u, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if got := dst.UserInfo(q.u)["name"]; got != name {
+		t.Errorf("name after reconnect is %v; want %v", got, name)
+	}
+	var last float64
+	for _, text := range []string{"one", "two"} {
+		req := expect(t, q.cl, "news")
+		if req.Params["text"] != text {
+			t.Errorf("replayed %v; want %q", req.Params["text"], text)
+		}
+		last, _ = req.Params["seq"].(float64)
+	}
+
+	if err := dst.Broadcast("news", chat.Object{"text": "three"}); err != nil {
+		t.Fatal(err)
+	}
+	// Greets and renames in the new chat are sequenced too.
+	if seq, _ := expect(t, q.cl, "news").Params["seq"].(float64); seq <= last {
+		t.Errorf("seq after import is %v; want greater than %v", seq, last)
+	}
+}
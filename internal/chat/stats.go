@@ -0,0 +1,67 @@
+package chat
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats contains chat statistics.
+type Stats struct {
+	// DroppedWrites is a number of broadcast writes shed because pool was
+	// saturated.
+	DroppedWrites uint64
+
+	// ShedBroadcasts is a number of broadcasts shed because global rate was
+	// exceeded or broadcast queue was full.
+	ShedBroadcasts uint64
+
+	// Authenticated and Anonymous are numbers of connected users marked and
+	// not marked by Chat.SetAuthenticated().
+	Authenticated int
+	Anonymous     int
+
+	// Unacked is a total number of broadcasts written to connected users but
+	// not acknowledged by them. It is counted only when ack window is set.
+	Unacked uint64
+
+	// Disconnects is a number of disconnects by reason name (see
+	// CloseReason).
+	Disconnects map[string]uint64
+}
+
+// Stats returns current chat statistics.
+func (c *Chat) Stats() Stats {
+	c.mu.RLock()
+	n, na := len(c.us), c.na
+	var unacked uint64
+	if c.ackWindow > 0 {
+		for _, u := range c.us {
+			unacked += u.Unacked()
+		}
+	}
+	c.mu.RUnlock()
+
+	return Stats{
+		DroppedWrites:  atomic.LoadUint64(&c.dropped),
+		ShedBroadcasts: atomic.LoadUint64(&c.shed),
+		Authenticated:  na,
+		Anonymous:      n - na,
+		Unacked:        unacked,
+		Disconnects:    c.disconnects(),
+	}
+}
+
+// Health returns cheap load report which does not take chat mutex. Backlog
+// is a number of broadcasts waiting for the writer and buffered for paused
+// users. In flight is a number of broadcasts and per-user writes which are
+// not done yet, so it grows when writes to users stall.
+func (c *Chat) Health() Object {
+	backlog := int64(len(c.out)+len(c.high)) + atomic.LoadInt64(&c.buffered)
+
+	return Object{
+		"connected":    atomic.LoadInt64(&c.connected),
+		"queueBacklog": backlog,
+		"inFlight":     c.flight.pending(),
+		"uptime":       int64(time.Since(c.started) / time.Second),
+	}
+}
@@ -0,0 +1,44 @@
+package chat
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// storm detects reconnect storms by number of registrations in a sliding
+// window.
+type storm struct {
+	mu        sync.Mutex
+	threshold int
+	window    time.Duration
+	delay     time.Duration
+
+	start     time.Time // Start of the current window.
+	cur, prev int
+}
+
+// register counts registration made at now. It returns random delay which
+// client should wait before the next reconnect, or zero if registration rate
+// is under the threshold.
+func (s *storm) register(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elapsed := now.Sub(s.start); elapsed >= 2*s.window {
+		s.start = now
+		s.cur, s.prev = 0, 0
+	} else if elapsed >= s.window {
+		s.start = s.start.Add(s.window)
+		s.cur, s.prev = 0, s.cur
+	}
+	s.cur++
+
+	// Approximate sliding window by weighting previous fixed window.
+	weight := 1 - float64(now.Sub(s.start))/float64(s.window)
+	n := float64(s.prev)*weight + float64(s.cur)
+	if n <= float64(s.threshold) {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(s.delay)) + 1)
+}
@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// sweeper periodically disconnects users which are idle for longer than
+// idle timeout or did not answer read probe within probe timeout, until chat
+// is shut down. Disconnects are spread randomly over the sweep interval to
+// avoid reconnect storms.
+func (c *Chat) sweeper() {
+	t := time.NewTicker(c.sweepInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-c.done:
+			return
+		}
+		c.sweep(time.Now())
+	}
+}
+
+// sweep schedules disconnects of users which are past their deadlines.
+func (c *Chat) sweep(now time.Time) {
+	c.mu.RLock()
+	us := c.us
+	c.mu.RUnlock()
+
+	idle := now.Add(-c.idleTimeout).UnixNano()
+	probe := now.Add(-c.probeTimeout).UnixNano()
+	for _, u := range us {
+		var (
+			reason CloseReason
+			active = atomic.LoadInt64(&u.active)
+		)
+		switch {
+		case c.idleTimeout > 0 && active <= idle:
+			reason = CloseIdle
+		case u.probeExpired(active, probe):
+			reason = CloseProbe
+		default:
+			continue
+		}
+		if !atomic.CompareAndSwapUint32(&u.swept, 0, 1) {
+			// Disconnect is already scheduled.
+			continue
+		}
+		u := u // For closure.
+		jitter := time.Duration(rand.Int63n(int64(c.sweepInterval)))
+		time.AfterFunc(jitter, func() {
+			if reason == CloseProbe {
+				// Connection is likely half-open, so no close frame.
+				u.setCloseReason(reason)
+				u.conn.Close()
+			} else {
+				u.closeFor(reason, "idle timeout")
+			}
+			c.Remove(u)
+		})
+	}
+}
+
+// touch marks user as active now.
+func (u *User) touch() {
+	atomic.StoreInt64(&u.active, time.Now().UnixNano())
+}
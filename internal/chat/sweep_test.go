@@ -0,0 +1,36 @@
+package chat_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/suryatresna/multiplayerengine/internal/chat"
+)
+
+func TestSweepDeadlines(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		opt    chat.Option
+		reason chat.CloseReason
+	}{
+		{"idle", chat.WithIdleTimeout(20*time.Millisecond, 10*time.Millisecond), chat.CloseIdle},
+		{"probe", chat.WithReadProbe(10*time.Millisecond, 20*time.Millisecond), chat.CloseProbe},
+	} {
+		removed := make(chan chat.CloseReason, 1)
+		c := chat.NewChat(nil, test.opt, chat.WithOnRemove(func(u *chat.User, r chat.CloseReason) {
+			removed <- r
+		}))
+		// Nothing is ever received, so probes are not answered.
+		if _, err := c.RegisterConn(discardConn{}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case r := <-removed:
+			if r != test.reason {
+				t.Errorf("%s: close reason is %v; want %v", test.name, r, test.reason)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: user is not swept", test.name)
+		}
+	}
+}
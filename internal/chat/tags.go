@@ -0,0 +1,73 @@
+package chat
+
+// Tag returns value of user tag with given key.
+func (u *User) Tag(key string) (string, bool) {
+	u.chat.mu.RLock()
+	defer u.chat.mu.RUnlock()
+
+	v, has := u.tags[key]
+	return v, has
+}
+
+// SetTag sets user tag, e.g. region or plan, which could be used by
+// BroadcastTag(). Empty value removes the tag.
+func (c *Chat) SetTag(u *User, key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ns[u.name] != u {
+		return
+	}
+	c.untag(u, key)
+	if value == "" {
+		return
+	}
+	if u.tags == nil {
+		u.tags = make(map[string]string)
+	}
+	u.tags[key] = value
+
+	values := c.tags[key]
+	if values == nil {
+		values = make(map[string]map[*User]bool)
+		c.tags[key] = values
+	}
+	if values[value] == nil {
+		values[value] = make(map[*User]bool)
+	}
+	values[value][u] = true
+}
+
+// BroadcastTag sends message to users having tag key with given value.
+// Recipients are looked up in index, so it is cheaper than BroadcastWhere()
+// for frequently used segments.
+func (c *Chat) BroadcastTag(key, value, method string, params Object) error {
+	c.mu.RLock()
+	us := make([]*User, 0, len(c.tags[key][value]))
+	for u := range c.tags[key][value] {
+		us = append(us, u)
+	}
+	c.mu.RUnlock()
+
+	if len(us) == 0 {
+		return nil
+	}
+	return c.broadcast(c.out, target{to: us}, method, params)
+}
+
+// untag removes user tag from index.
+// mutex must be held.
+func (c *Chat) untag(u *User, key string) {
+	old, has := u.tags[key]
+	if !has {
+		return
+	}
+	delete(u.tags, key)
+	delete(c.tags[key][old], u)
+	if len(c.tags[key][old]) == 0 {
+		delete(c.tags[key], old)
+	}
+	if len(c.tags[key]) == 0 {
+		delete(c.tags, key)
+	}
+}
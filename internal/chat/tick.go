@@ -0,0 +1,35 @@
+package chat
+
+import "time"
+
+// ticker periodically broadcasts "tick" notice with server time until chat
+// is shut down.
+func (c *Chat) ticker() {
+	t := time.NewTicker(c.tickInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			c.tick()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// tick sends "tick" notice to all users. Ticks have no sequence number and
+// are not kept in history. Tick is skipped if broadcast queue is full.
+func (c *Chat) tick() {
+	m, err := c.newMessage(Request{Method: "tick", Params: Object{
+		"time": timestamp(),
+	}})
+	if err != nil {
+		return
+	}
+	c.flight.add(1)
+	select {
+	case c.out <- &m:
+	default:
+		c.flight.done()
+	}
+}
@@ -0,0 +1,53 @@
+package chat_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/suryatresna/multiplayerengine/internal/chat"
+)
+
+// waitClosed waits until server closes connection of p.
+func waitClosed(t *testing.T, p peer) {
+	t.Helper()
+
+	tm := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-p.cl.Subscribe():
+			if !ok {
+				return
+			}
+		case <-tm:
+			t.Fatal("connection is not closed")
+		}
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	c := chat.NewChat(nil, chat.WithReadTimeout(timeout))
+
+	var buf bytes.Buffer
+	ws.WriteFrame(&buf, ws.MaskFrame(ws.NewTextFrame([]byte(`{"method":"count","id":1}`))))
+	frame := buf.Bytes()
+
+	// Idle client is not dropped.
+	idle := connect(t, c)
+	time.Sleep(3 * timeout)
+	if _, err := idle.cl.Call(callContext(t), "count", nil); err != nil {
+		t.Fatalf("count after idle: %v", err)
+	}
+
+	// Clients stalled mid-header or mid-payload are.
+	for _, part := range [][]byte{frame[:1], frame[:len(frame)-4]} {
+		p := connect(t, c)
+		flush(t, c)
+		if _, err := p.raw.Write(part); err != nil {
+			t.Fatal(err)
+		}
+		waitClosed(t, p)
+	}
+}
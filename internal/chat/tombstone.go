@@ -0,0 +1,39 @@
+package chat
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrRecentlyLeft returned by SendTo when user with given name left the chat
+// recently, so caller could queue message for offline delivery instead of
+// discarding it.
+var ErrRecentlyLeft = fmt.Errorf("chat: user recently left")
+
+// bury leaves tombstone for removed user.
+// mutex must be held.
+func (c *Chat) bury(user *User) {
+	if c.tombstoneTTL <= 0 {
+		return
+	}
+	name := user.name
+	expires := time.Now().Add(c.tombstoneTTL)
+	c.ts[name] = expires
+	time.AfterFunc(c.tombstoneTTL, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if c.ts[name] == expires {
+			delete(c.ts, name)
+		}
+	})
+}
+
+// recentlyLeft reports whether there is live tombstone for name.
+func (c *Chat) recentlyLeft(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	expires, has := c.ts[name]
+	return has && time.Now().Before(expires)
+}
@@ -0,0 +1,38 @@
+package chat
+
+import "context"
+
+// Tracer contains hooks for tracing of chat requests and broadcasts.
+// It is intentionally small so any tracing library could be wired in by
+// embedders. Trace context sent by client (e.g. params["traceparent"]) is
+// available in req.Params.
+type Tracer interface {
+	// TraceStart is called before request or broadcast with given method is
+	// processed. Parent is context of request on whose behalf broadcast is
+	// sent, e.g. "publish", and context.Background() otherwise. Returned
+	// context is passed to registered method handlers and returned func is
+	// called when processing is done.
+	TraceStart(parent context.Context, method string, req *Request) (context.Context, func())
+}
+
+// TracerFunc is an adapter to allow use of ordinary functions as Tracer.
+type TracerFunc func(parent context.Context, method string, req *Request) (context.Context, func())
+
+// TraceStart calls f(parent, method, req).
+func (f TracerFunc) TraceStart(parent context.Context, method string, req *Request) (context.Context, func()) {
+	return f(parent, method, req)
+}
+
+func (c *Chat) traceStart(parent context.Context, method string, req *Request) (context.Context, func()) {
+	if c.tracer == nil {
+		return parent, func() {}
+	}
+	ctx, end := c.tracer.TraceStart(parent, method, req)
+	if ctx == nil {
+		ctx = parent
+	}
+	if end == nil {
+		end = func() {}
+	}
+	return ctx, end
+}
@@ -1,45 +1,143 @@
 package chat
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
 	"io"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 )
 
+// ErrClosed returned by Receive when client closed connection normally.
+var ErrClosed = fmt.Errorf("chat: connection closed by client")
+
 // User represents user connection.
 // It contains logic of receiving and sending messages.
 // That is, there are no active reader or writer. Some other layer of the
 // application should call Receive() to read user's incoming message.
 type User struct {
+	lastSeq      uint64 // Accessed atomically, must be 64-bit aligned.
+	active       int64  // Time of the last received message in unix nanoseconds.
+	acked        uint64 // Highest acknowledged broadcast sequence number.
+	bytesIn      uint64
+	bytesOut     uint64
+	pendingBytes int64  // Size of scheduled broadcast writes.
+	probed       int64  // Time of unanswered read probe, zero if none.
+	swept        uint32 // Set when sweeper disconnect is scheduled.
+	spectator    uint32 // Set for read-only spectators.
+	disconnect   uint32 // Reason of server-side disconnect.
+	handling     int32  // Number of registered handlers in flight.
+	waiting      uint32 // Set while user is in the waiting room.
+	probing      uint32 // Set while read probe is not answered.
+
 	io   sync.Mutex
 	conn io.ReadWriteCloser
 
-	id   uint
-	name string
-	chat *Chat
+	id     uint
+	name   string
+	avatar string
+	chat   *Chat
+
+	connectedAt time.Time
+
+	protocol    uint32
+	subprotocol string
+
+	mu         sync.Mutex
+	blocked    map[uint]bool // Ids of blocked users.
+	features   map[string]bool
+	receipts   map[string]bool // Names of users receiving read receipts.
+	lastRename time.Time
+
+	authenticated bool              // Guarded by chat mutex.
+	greeted       bool              // Guarded by chat mutex.
+	watching      []string          // Guarded by chat mutex.
+	identity      string            // Guarded by chat mutex.
+	tags          map[string]string // Guarded by chat mutex.
+	reauth        reauth
+	lifetime      *time.Timer // Guarded by user mutex.
+	pause         pause
+	budget        budget
+	flight        inflight
+	q             queue
+	ring          ring
+	idempotency   idempotency
 }
 
 // Receive reads next message from user's underlying connection.
 // It blocks until full message received.
 func (u *User) Receive() error {
 	req, err := u.readRequest()
+	if err == ErrTooDeep {
+		return u.writeErrorTo(&Request{}, Object{
+			"error": "request is nested too deep",
+			"code":  ErrCodeParse,
+		})
+	}
+	if err == ErrByteBudget {
+		u.closeFor(CloseRate, "byte budget exceeded")
+		u.chat.Remove(u)
+		return err
+	}
 	if err != nil {
 		u.conn.Close()
+		if u.chat.shuttingDown() {
+			return ErrShuttingDown
+		}
 		return err
 	}
+	u.touch()
 	if req == nil {
 		// Handled some control message.
 		return nil
 	}
+	if u.Waiting() {
+		return u.writeErrorTo(req, Object{
+			"error": "waiting",
+			"code":  ErrCodeBusy,
+		})
+	}
+
+	ctx, end := u.chat.traceStart(context.Background(), req.Method, req)
+	defer end()
+	ctx, cancel := withRequestDeadline(ctx, req)
+	defer cancel()
+
+	switch req.Method {
+	case "rename", "publish", "secure_publish", "typing":
+		if u.Spectator() {
+			return u.writeErrorTo(req, Object{
+				"error": "forbidden",
+				"code":  ErrCodeForbidden,
+			})
+		}
+	}
+	if req.Method == "secure_publish" && u.chat.anonymous {
+		// Direct messages would reveal the sender.
+		return u.writeErrorTo(req, Object{
+			"error": "forbidden",
+			"code":  ErrCodeForbidden,
+		})
+	}
+
 	switch req.Method {
 	case "rename":
-		name, ok := req.Params["name"].(string)
-		if !ok {
+		name, ok := req.Params.GetString("name")
+		if !ok || strings.TrimSpace(name) == "" {
 			return u.writeErrorTo(req, Object{
 				"error": "bad params",
+				"code":  ErrCodeInvalidParams,
+			})
+		}
+		if !u.canRename() {
+			return u.writeErrorTo(req, Object{
+				"error": "too many renames",
+				"code":  ErrCodeRateLimited,
 			})
 		}
 		prev, ok := u.chat.Rename(u, name)
@@ -48,20 +146,315 @@ func (u *User) Receive() error {
 				"error": "already exists",
 			})
 		}
-		u.chat.Broadcast("rename", Object{
+		u.renamed()
+		u.chat.presence(prev, false)
+		u.chat.presence(name, true)
+		u.chat.broadcastRoster("rename", Object{
 			"prev": prev,
 			"name": name,
 			"time": timestamp(),
 		})
 		return u.writeResultTo(req, nil)
+	case "hello":
+		version, ok := req.Params.GetInt("version")
+		fs, ok2 := features(req.Params)
+		if !ok || !ok2 || version < ProtocolV1 || version > ProtocolV2 {
+			return u.writeErrorTo(req, Object{
+				"error": "bad params",
+				"code":  ErrCodeInvalidParams,
+			})
+		}
+		u.SetProtocol(version)
+		u.SetFeatures(fs...)
+		return u.writeResultTo(req, Object{
+			"version": u.Protocol(),
+		})
+	case "count":
+		return u.writeQueryResultTo(ctx, req, func() (Object, error) {
+			return Object{
+				"count": u.chat.Count(),
+			}, nil
+		})
+	case "health":
+		return u.writeResultTo(req, u.chat.Health())
+	case "whoami":
+		return u.writeQueryResultTo(ctx, req, func() (Object, error) {
+			return u.chat.UserInfo(u), nil
+		})
+	case "list":
+		return u.writeQueryResultTo(ctx, req, func() (Object, error) {
+			return Object{
+				"users": u.chat.Users(),
+			}, nil
+		})
+	case "block", "unblock":
+		name, ok := req.Params.GetString("name")
+		if !ok {
+			return u.writeErrorTo(req, Object{
+				"error": "bad params",
+				"code":  ErrCodeInvalidParams,
+			})
+		}
+		other, ok := u.chat.Lookup(name)
+		if !ok {
+			return u.writeErrorTo(req, Object{
+				"error": "no such user",
+			})
+		}
+		u.setBlocked(other, req.Method == "block")
+		return u.writeResultTo(req, nil)
+	case "reauth":
+		if u.chat.reauthInterval <= 0 {
+			return u.writeErrorTo(req, Object{
+				"error": "not implemented",
+			})
+		}
+		token, _ := req.Params.GetString("token")
+		if !u.completeReauth(token) {
+			u.writeErrorTo(req, Object{
+				"error": "reauth failed",
+			})
+			if u.chat.AuthFailed(u.remoteAddr()) {
+				u.closeFor(CloseRate, "too many authentication failures")
+			} else {
+				u.closeFor(CloseReauth, "reauth failed")
+			}
+			u.chat.Remove(u)
+			return nil
+		}
+		u.chat.AuthSucceeded(u.remoteAddr())
+		return u.writeResultTo(req, nil)
+	case "replay_from":
+		seq, ok := req.Params.GetInt("seq")
+		if !ok || seq < 0 {
+			return u.writeErrorTo(req, Object{
+				"error": "bad params",
+				"code":  ErrCodeInvalidParams,
+			})
+		}
+		return u.writeQueryResultTo(ctx, req, func() (Object, error) {
+			reqs, gap := u.chat.Replay(u, uint64(seq))
+			if reqs == nil {
+				reqs = []Request{}
+			}
+			return Object{
+				"messages": reqs,
+				"gap":      gap,
+			}, nil
+		})
+	case "ack":
+		seq, ok := req.Params.GetInt("seq")
+		if !ok || seq < 0 {
+			return u.writeErrorTo(req, Object{
+				"error": "bad params",
+				"code":  ErrCodeInvalidParams,
+			})
+		}
+		u.ack(uint64(seq))
+		return nil
+	case "nack":
+		missing, ok := req.Params.GetIntSlice("missing")
+		if !ok || len(missing) > u.chat.maxNack {
+			return u.writeErrorTo(req, Object{
+				"error": "bad params",
+				"code":  ErrCodeInvalidParams,
+			})
+		}
+		seqs := make([]uint64, 0, len(missing))
+		for _, seq := range missing {
+			if seq < 0 {
+				return u.writeErrorTo(req, Object{
+					"error": "bad params",
+					"code":  ErrCodeInvalidParams,
+				})
+			}
+			seqs = append(seqs, uint64(seq))
+		}
+		unavailable, err := u.chat.Resend(u, seqs)
+		if err != nil {
+			return err
+		}
+		if unavailable == nil {
+			unavailable = []uint64{}
+		}
+		return u.writeResultTo(req, Object{
+			"unavailable": unavailable,
+		})
+	case "pause":
+		u.Pause()
+		return u.writeResultTo(req, nil)
+	case "resume":
+		// Result is written after buffered broadcasts.
+		gap, err := u.Resume()
+		if err != nil {
+			return err
+		}
+		return u.writeResultTo(req, Object{
+			"gap": gap,
+		})
+	case "typing":
+		name := u.name
+		if u.chat.anonymous {
+			name = u.chat.anonymousAuthor
+		}
+		if err := u.chat.BroadcastCoalesced("typing", name); err != ErrBusy {
+			return err
+		}
+		return u.writeErrorTo(req, Object{
+			"error": "busy",
+			"code":  ErrCodeBusy,
+		})
 	case "publish":
-		req.Params["author"] = u.name
+		if req.Params == nil {
+			req.Params = Object{}
+		}
+		if u.chat.textTooLong(req.Params) {
+			return u.writeErrorTo(req, Object{
+				"error": "message too long",
+				"code":  ErrCodeInvalidParams,
+			})
+		}
+		if _, has := req.Params["attachment"]; has {
+			if a, ok := req.Params.GetObject("attachment"); !ok || !u.chat.validAttachment(a) {
+				return u.writeErrorTo(req, Object{
+					"error": "bad attachment",
+					"code":  ErrCodeInvalidParams,
+				})
+			}
+		}
 		req.Params["time"] = timestamp()
-		u.chat.Broadcast("publish", req.Params)
-	default:
-		return u.writeErrorTo(req, Object{
-			"error": "not implemented",
+		if u.chat.anonymous {
+			// Sender is still known to the chat as target.from.
+			req.Params["author"] = u.chat.anonymousAuthor
+			delete(req.Params, "avatar")
+		} else {
+			req.Params["author"] = u.name
+			withAvatar(u, req.Params)
+		}
+		mentions, ok := u.chat.mentions(req.Params)
+		if !ok {
+			return u.writeErrorTo(req, Object{
+				"error": "bad mentions",
+				"code":  ErrCodeInvalidParams,
+			})
+		}
+		if mentions != nil {
+			// Only resolved names are broadcasted.
+			req.Params["mentions"] = mentions
+		}
+		key, keyed := req.Params.GetString("key")
+		keyed = keyed && u.chat.idempotencySize > 0
+		if keyed && len(key) > maxIdempotencyKeyLen {
+			return u.writeErrorTo(req, Object{
+				"error": "bad key",
+				"code":  ErrCodeInvalidParams,
+			})
+		}
+		var (
+			seq uint64
+			dup bool
+			err error
+		)
+		if keyed {
+			seq, dup, err = u.publishOnce(ctx, key, req.Params)
+		} else {
+			seq, dup, err = u.publish(ctx, req.Params)
+		}
+		if err == ErrBusy {
+			return u.writeErrorTo(req, Object{
+				"error": "busy",
+				"code":  ErrCodeBusy,
+			})
+		}
+		if err != nil {
+			return err
+		}
+		if !dup {
+			u.notifyMentions(seq, req.Params, mentions)
+		}
+		if (keyed || u.chat.squelch != nil) && req.ID != nil {
+			return u.writeResultTo(req, Object{
+				"seq":       seq,
+				"duplicate": dup,
+			})
+		}
+	case "secure_publish":
+		// Ciphertext is opaque for the server, only envelope is validated.
+		to, ok1 := req.Params.GetString("recipient")
+		ct, ok2 := req.Params.GetString("ciphertext")
+		if !ok1 || !ok2 {
+			return u.writeErrorTo(req, Object{
+				"error": "bad params",
+				"code":  ErrCodeInvalidParams,
+			})
+		}
+		params := Object{
+			"author":     u.name,
+			"recipient":  to,
+			"time":       timestamp(),
+			"ciphertext": ct,
+		}
+		var id uint64
+		if u.chat.receipts != nil {
+			if r, has := u.chat.Lookup(to); has {
+				id = u.chat.receipts.add(u, r)
+				params["id"] = id
+			}
+		}
+		err := u.chat.sendTo(u, to, "secure_publish", params)
+		if err == ErrNoSuchUser {
+			return u.writeErrorTo(req, Object{
+				"error": "no such user",
+			})
+		}
+		if err == ErrRecentlyLeft {
+			return u.writeErrorTo(req, Object{
+				"error": "recently left",
+			})
+		}
+		if err == ErrSlowRecipient {
+			return u.writeErrorTo(req, Object{
+				"error": "delivery failed",
+				"code":  ErrCodeBusy,
+			})
+		}
+		if err != nil {
+			return err
+		}
+		if id != 0 {
+			return u.writeResultTo(req, Object{"id": id})
+		}
+		return u.writeResultTo(req, nil)
+	case "read", "receipts":
+		if u.chat.receipts == nil {
+			return u.handle(ctx, req)
+		}
+		if req.Method == "read" {
+			return u.read(req)
+		}
+		return u.setReceipts(req)
+	case "watch":
+		return u.watch(req)
+	case "pin", "unpin":
+		return u.pin(req)
+	case "spectate":
+		return u.spectate(req)
+	case "queue_report":
+		if u.chat.admin == nil || !u.chat.admin(u) {
+			return u.writeErrorTo(req, Object{
+				"error": "forbidden",
+				"code":  ErrCodeForbidden,
+			})
+		}
+		return u.writeResultTo(req, Object{
+			"queues": u.chat.QueueReport(),
 		})
+	case "methods":
+		return u.writeResultTo(req, Object{
+			"methods": u.chat.Methods(),
+		})
+	default:
+		return u.handle(ctx, req)
 	}
 	return nil
 }
@@ -72,23 +465,76 @@ func (u *User) readRequest() (*Request, error) {
 	u.io.Lock()
 	defer u.io.Unlock()
 
+	// Receive is called when connection is readable, so deadline covers
+	// the header too, and client stalled mid-header does not hold io mutex.
+	if t := u.chat.readTimeout; t > 0 {
+		if err := u.setReadDeadline(time.Now().Add(t)); err != nil {
+			return nil, err
+		}
+		defer u.setReadDeadline(time.Time{})
+	}
+	// Deadline set above could override the one set by Shutdown.
+	if u.chat.shuttingDown() {
+		return nil, ErrShuttingDown
+	}
+
 	h, r, err := wsutil.NextReader(u.conn, ws.StateServerSide)
 	if err != nil {
 		return nil, err
 	}
+	if h.OpCode == ws.OpClose {
+		// Reply with close frame, as the handler does for other control
+		// frames, but report clean close distinctly from I/O errors.
+		wsutil.ControlFrameHandler(u.conn, ws.StateServerSide)(h, r)
+		return nil, ErrClosed
+	}
 	if h.OpCode.IsControl() {
 		return nil, wsutil.ControlFrameHandler(u.conn, ws.StateServerSide)(h, r)
 	}
 
+	// Message is read into pooled buffer. Decoding copies everything it
+	// keeps, so buffer could be reused right after it.
+	buf := getBuffer(h.Length)
+	defer putBuffer(buf)
+	// Header holds length of the first frame only, so bytes are counted
+	// after continuation frames are read, but no more than one byte over
+	// the budget is read.
+	var src io.Reader = r
+	if n := u.allowance(); n >= 0 {
+		src = io.LimitReader(r, n+1)
+	}
+	_, err = buf.ReadFrom(src)
+	if err := u.received(int64(buf.Len())); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if n := u.chat.maxDepth; n > 0 && tooDeep(buf.Bytes(), n) {
+		// Depth is checked before decoding, which allocates for every level.
+		return nil, ErrTooDeep
+	}
+
 	req := &Request{}
-	decoder := json.NewDecoder(r)
-	if err := decoder.Decode(req); err != nil {
+	if err := u.chat.decode(buf.Bytes(), req); err != nil {
 		return nil, err
 	}
 
 	return req, nil
 }
 
+// setReadDeadline sets read deadline on the user connection if it supports
+// deadlines.
+func (u *User) setReadDeadline(t time.Time) error {
+	conn, ok := u.conn.(interface {
+		SetReadDeadline(time.Time) error
+	})
+	if !ok {
+		return nil
+	}
+	return conn.SetReadDeadline(t)
+}
+
 func (u *User) writeErrorTo(req *Request, err Object) error {
 	return u.write(Error{
 		ID:    req.ID,
@@ -110,25 +556,162 @@ func (u *User) writeNotice(method string, params Object) error {
 	})
 }
 
-func (u *User) write(x interface{}) error {
-	w := wsutil.NewWriter(u.conn, ws.StateServerSide, ws.OpText)
-	encoder := json.NewEncoder(w)
+// Authenticated reports whether user is authenticated.
+func (u *User) Authenticated() bool {
+	u.chat.mu.RLock()
+	defer u.chat.mu.RUnlock()
 
-	u.io.Lock()
-	defer u.io.Unlock()
+	return u.authenticated
+}
+
+// Spectator reports whether user is read-only spectator.
+func (u *User) Spectator() bool {
+	return atomic.LoadUint32(&u.spectator) == 1
+}
 
-	if err := encoder.Encode(x); err != nil {
+// spectate handles admin "spectate" request, which makes user with given
+// name spectator or promotes them to participant.
+func (u *User) spectate(req *Request) error {
+	if u.chat.admin == nil || !u.chat.admin(u) {
+		return u.writeErrorTo(req, Object{
+			"error": "forbidden",
+			"code":  ErrCodeForbidden,
+		})
+	}
+	name, ok1 := req.Params.GetString("name")
+	spectator, ok2 := req.Params.GetBool("spectator")
+	if !ok1 || !ok2 {
+		return u.writeErrorTo(req, Object{
+			"error": "bad params",
+			"code":  ErrCodeInvalidParams,
+		})
+	}
+	target, has := u.chat.Lookup(name)
+	if !has {
+		return u.writeErrorTo(req, Object{
+			"error": "not found",
+			"code":  ErrCodeInvalidParams,
+		})
+	}
+	u.chat.SetSpectator(target, spectator)
+	return u.writeResultTo(req, nil)
+}
+
+// blocks reports whether user blocked messages from other.
+func (u *User) blocks(other *User) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.blocked[other.id]
+}
+
+func (u *User) setBlocked(other *User, blocked bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if !blocked {
+		delete(u.blocked, other.id)
+		return
+	}
+	if u.blocked == nil {
+		u.blocked = make(map[uint]bool)
+	}
+	u.blocked[other.id] = true
+}
+
+// LastSeq returns sequence number of the last broadcast written to user.
+func (u *User) LastSeq() uint64 {
+	return atomic.LoadUint64(&u.lastSeq)
+}
+
+func (u *User) setLastSeq(seq uint64) {
+	for {
+		last := atomic.LoadUint64(&u.lastSeq)
+		if seq <= last || atomic.CompareAndSwapUint64(&u.lastSeq, last, seq) {
+			return
+		}
+	}
+}
+
+// Subprotocol returns websocket subprotocol negotiated during handshake.
+func (u *User) Subprotocol() string {
+	return u.subprotocol
+}
+
+// Protocol returns protocol version used by user.
+func (u *User) Protocol() int {
+	if v := atomic.LoadUint32(&u.protocol); v != 0 {
+		return int(v)
+	}
+	return ProtocolV1
+}
+
+// SetProtocol sets protocol version used to write messages to user.
+// Embedders could call it right after Register, e.g. when version is passed
+// as query parameter of the handshake request.
+func (u *User) SetProtocol(version int) {
+	atomic.StoreUint32(&u.protocol, uint32(version))
+}
+
+// write encodes x and writes it to the user connection.
+// Message is fully encoded and framed before the write, so the connection
+// never receives partially encoded frame.
+func (u *User) write(x interface{}) error {
+	y, err := u.chat.encode(u.Protocol(), x)
+	if err != nil {
+		return err
+	}
+	bts, err := frame(y)
+	if err != nil {
 		return err
 	}
 
-	return w.Flush()
+	return u.writeRaw(bts)
 }
 
 func (u *User) writeRaw(p []byte) error {
 	u.io.Lock()
-	defer u.io.Unlock()
+	n, err := u.writeRetry(p)
+	u.io.Unlock()
 
-	_, err := u.conn.Write(p)
+	if err != nil && u.chat.classifyWrite(u, n, err) == WriteErrorFatal {
+		u.writeFailed()
+	}
 
 	return err
 }
+
+// writeRetry writes p retrying once after transient error. It must be called
+// with u.io held.
+func (u *User) writeRetry(p []byte) (int, error) {
+	n, err := u.writeOnce(p)
+	if err != nil && u.chat.classifyWrite(u, n, err) == WriteErrorTransient {
+		n, err = u.writeOnce(p)
+	}
+	return n, err
+}
+
+// writeOnce writes p to the connection with write timeout. It must be called
+// with u.io held.
+func (u *User) writeOnce(p []byte) (int, error) {
+	if size := u.chat.chunkSize; size > 0 && len(p) > size {
+		return u.writeChunks(p, size)
+	}
+	if err := u.setWriteDeadline(u.chat.writeTimeout); err != nil {
+		return 0, err
+	}
+	n, err := u.conn.Write(p)
+	u.written(n)
+	return n, err
+}
+
+// close sends close frame with given code and reason and closes underlying
+// connection.
+func (u *User) close(code ws.StatusCode, reason string) error {
+	u.io.Lock()
+	defer u.io.Unlock()
+
+	ws.WriteFrame(u.conn, ws.NewCloseFrame(ws.NewCloseFrameBody(code, reason)))
+
+	return u.conn.Close()
+}
@@ -0,0 +1,59 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// recordConn records everything written.
+type recordConn struct {
+	bytes.Buffer
+}
+
+func (c *recordConn) Read([]byte) (int, error) { return 0, fmt.Errorf("not readable") }
+func (c *recordConn) Close() error             { return nil }
+
+// failing fails to marshal.
+type failing struct{}
+
+func (failing) MarshalJSON() ([]byte, error) {
+	return nil, fmt.Errorf("failing")
+}
+
+func TestWriteUnmarshalable(t *testing.T) {
+	conn := &recordConn{}
+	u := &User{chat: NewChat(nil), conn: conn}
+
+	// Keys are encoded in order, so "a" is encoded before failing "z".
+	for _, x := range []interface{}{
+		Object{"ch": make(chan int)},
+		Object{"a": "ok", "z": failing{}},
+	} {
+		if err := u.write(x); err == nil {
+			t.Errorf("write(%v) succeeded", x)
+		}
+	}
+	if conn.Len() != 0 {
+		t.Fatalf("failed writes wrote %d bytes", conn.Len())
+	}
+
+	if err := u.write(Object{"a": "ok"}); err != nil {
+		t.Fatal(err)
+	}
+	p, op, err := wsutil.ReadServerData(&conn.Buffer)
+	if err != nil || op != ws.OpText {
+		t.Fatalf("read frame: op %v, error %v", op, err)
+	}
+	var obj Object
+	if err := json.Unmarshal(p, &obj); err != nil || obj["a"] != "ok" {
+		t.Errorf("written %q; error %v", p, err)
+	}
+	if conn.Len() != 0 {
+		t.Errorf("%d bytes left after the frame", conn.Len())
+	}
+}
@@ -0,0 +1,88 @@
+package chat
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrFull returned by Register when chat and its waiting room are full.
+var ErrFull = fmt.Errorf("chat: server is full")
+
+// Waiting reports whether user is in the waiting room (see WithMaxUsers()).
+// Requests of waiting users are rejected.
+func (u *User) Waiting() bool {
+	return atomic.LoadUint32(&u.waiting) == 1
+}
+
+// full reports whether new users must wait.
+// mutex must be held.
+func (c *Chat) full() bool {
+	return c.maxUsers > 0 && (len(c.us) >= c.maxUsers || len(c.wait) > 0)
+}
+
+// enqueueWaiting puts user in the waiting room and returns its position.
+// mutex must be held.
+func (c *Chat) enqueueWaiting(user *User) (int, error) {
+	if len(c.wait) >= c.maxWaiting {
+		return 0, ErrFull
+	}
+	atomic.StoreUint32(&user.waiting, 1)
+	c.wait = append(c.wait, user)
+	return len(c.wait), nil
+}
+
+// leaveWaiting removes user from the waiting room. It returns false if user
+// is not waiting anymore.
+func (c *Chat) leaveWaiting(user *User) bool {
+	c.mu.Lock()
+	i := -1
+	for j, u := range c.wait {
+		if u == user {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		c.mu.Unlock()
+		return false
+	}
+	c.wait = append(c.wait[:i:i], c.wait[i+1:]...)
+	wait := c.wait
+	c.mu.Unlock()
+
+	notifyPositions(wait[i:], i)
+	return true
+}
+
+// promote admits waiting users while there are free slots.
+func (c *Chat) promote() {
+	c.mu.Lock()
+	var admitted []*User
+	for len(c.wait) > 0 && len(c.us) < c.maxUsers {
+		u := c.wait[0]
+		c.wait = c.wait[1:]
+		atomic.StoreUint32(&u.waiting, 0)
+		c.admit(u)
+		admitted = append(admitted, u)
+	}
+	wait := c.wait
+	c.mu.Unlock()
+
+	if len(admitted) == 0 {
+		return
+	}
+	for _, u := range admitted {
+		c.welcomeUser(u)
+	}
+	notifyPositions(wait, 0)
+}
+
+// notifyPositions sends "queue_position" notices to waiting users which
+// positions start from offset+1.
+func notifyPositions(us []*User, offset int) {
+	for i, u := range us {
+		u.writeNotice("queue_position", Object{
+			"position": offset + i + 1,
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package chat
+
+// maxWatch is the maximum number of names watched by single user.
+const maxWatch = 100
+
+// Watch sets names which presence is watched by user, replacing previous
+// ones. User receives "presence" notice whenever any of watched names joins
+// or leaves the chat. It returns watched names which are online now.
+func (c *Chat) Watch(u *User, names []string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.unwatch(u)
+	online := make([]string, 0, len(names))
+	for _, name := range names {
+		ws := c.watchers[name]
+		if ws == nil {
+			ws = make(map[*User]bool)
+			c.watchers[name] = ws
+		}
+		if ws[u] {
+			continue
+		}
+		ws[u] = true
+		u.watching = append(u.watching, name)
+		if _, has := c.ns[name]; has {
+			online = append(online, name)
+		}
+	}
+	return online
+}
+
+// unwatch removes all watched names of user.
+// mutex must be held.
+func (c *Chat) unwatch(u *User) {
+	for _, name := range u.watching {
+		ws := c.watchers[name]
+		delete(ws, u)
+		if len(ws) == 0 {
+			delete(c.watchers, name)
+		}
+	}
+	u.watching = nil
+}
+
+// presence notifies watchers of name that it became online or offline.
+func (c *Chat) presence(name string, online bool) {
+	c.mu.RLock()
+	ws := make([]*User, 0, len(c.watchers[name]))
+	for u := range c.watchers[name] {
+		ws = append(ws, u)
+	}
+	c.mu.RUnlock()
+
+	if len(ws) == 0 {
+		return
+	}
+	params := Object{
+		"name":   name,
+		"online": online,
+		"time":   timestamp(),
+	}
+	for _, u := range ws {
+		u.writeNotice("presence", params)
+	}
+}
+
+// watch handles "watch" request.
+func (u *User) watch(req *Request) error {
+	names, ok := req.Params.GetStringSlice("names")
+	if !ok || len(names) > maxWatch {
+		return u.writeErrorTo(req, Object{
+			"error": "bad params",
+			"code":  ErrCodeInvalidParams,
+		})
+	}
+	return u.writeResultTo(req, Object{
+		"online": u.chat.Watch(u, names),
+	})
+}
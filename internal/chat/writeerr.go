@@ -0,0 +1,67 @@
+package chat
+
+import (
+	"net"
+	"time"
+)
+
+// WriteErrorClass describes how error of write to user connection is
+// handled.
+type WriteErrorClass int
+
+const (
+	// WriteErrorFatal means that connection is broken, e.g. on broken pipe or
+	// use of closed connection. User is disconnected and removed from chat.
+	WriteErrorFatal WriteErrorClass = iota
+	// WriteErrorTransient means that write could succeed later, e.g. on write
+	// timeout. Write is retried once, and message is dropped if retry fails
+	// too.
+	WriteErrorTransient
+	// WriteErrorIgnore means that message is dropped and user stays in chat.
+	WriteErrorIgnore
+)
+
+// ClassifyWriteError is the default write error classifier. Timeouts are
+// transient, all other errors are fatal.
+func ClassifyWriteError(u *User, err error) WriteErrorClass {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return WriteErrorTransient
+	}
+	return WriteErrorFatal
+}
+
+// classifyWrite returns class of the error of write which wrote n bytes.
+// Frame which was written partially corrupts the stream, so such errors are
+// always fatal.
+func (c *Chat) classifyWrite(u *User, n int, err error) WriteErrorClass {
+	if n > 0 {
+		return WriteErrorFatal
+	}
+	if c.classify != nil {
+		return c.classify(u, err)
+	}
+	return ClassifyWriteError(u, err)
+}
+
+// writeFailed disconnects user after fatal write error. It is done in
+// separate goroutine because writes could be made by the broadcast writer.
+func (u *User) writeFailed() {
+	u.setCloseReason(CloseWriteError)
+	u.conn.Close()
+	go u.chat.Remove(u)
+}
+
+// setWriteDeadline sets write deadline d from now on the user connection if
+// d is not zero and connection supports deadlines.
+func (u *User) setWriteDeadline(d time.Duration) error {
+	if d == 0 {
+		return nil
+	}
+	conn, ok := u.conn.(interface {
+		SetWriteDeadline(time.Time) error
+	})
+	if !ok {
+		return nil
+	}
+	return conn.SetWriteDeadline(time.Now().Add(d))
+}